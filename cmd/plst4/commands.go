@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/errs"
+	"github.com/btmxh/plst4/internal/services"
+)
+
+// Maintenance commands, run as `plst4 <command>` instead of the server.
+func runCommand(name string) error {
+	switch name {
+	case "repair-counters":
+		return repairCounters()
+	default:
+		return fmt.Errorf("Unknown command: %s", name)
+	}
+}
+
+func repairCounters() error {
+	handler := errs.NewLogErrorHandler("Playlist counter repair error", func(err error) error { return nil })
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return errors.New("Unable to begin transaction")
+	}
+	defer tx.Rollback()
+
+	numRepaired, hasErr := services.RepairPlaylistCounters(tx)
+	if hasErr || tx.Commit() {
+		return errors.New("Unable to repair playlist counters")
+	}
+
+	slog.Info("Playlist counters repaired", "playlists", numRepaired)
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/btmxh/plst4/internal/auth"
 	"github.com/btmxh/plst4/internal/db"
@@ -12,6 +14,7 @@ import (
 	"github.com/btmxh/plst4/internal/mailer"
 	"github.com/btmxh/plst4/internal/media"
 	"github.com/btmxh/plst4/internal/routes"
+	"github.com/btmxh/plst4/internal/services"
 	"github.com/joho/godotenv"
 	"github.com/lmittmann/tint"
 )
@@ -51,6 +54,13 @@ func main() {
 	defer db.CloseDB()
 	slog.Info("Database connection initialized")
 
+	if len(os.Args) > 1 {
+		if err = runCommand(os.Args[1]); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	if err = mailer.InitMailer(); err != nil {
 		panic(err)
 	}
@@ -59,6 +69,66 @@ func main() {
 		panic(err)
 	}
 
+	jobWorkers := services.DefaultJobWorkers
+	if jobWorkersStr, ok := os.LookupEnv("JOB_WORKERS"); ok {
+		value, err := strconv.Atoi(jobWorkersStr)
+		if err != nil || value <= 0 {
+			slog.Warn("Invalid value for JOB_WORKERS environment variable", "value", jobWorkersStr, "err", err)
+		} else {
+			jobWorkers = value
+		}
+	}
+	services.StartJobWorkers(jobWorkers)
+
+	thumbnailCacheDir := services.DefaultThumbnailCacheDir
+	if dir, ok := os.LookupEnv("THUMBNAIL_CACHE_DIR"); ok {
+		thumbnailCacheDir = dir
+	}
+
+	var thumbnailCacheSize int64 = services.DefaultThumbnailCacheSize
+	if sizeStr, ok := os.LookupEnv("THUMBNAIL_CACHE_SIZE"); ok {
+		value, err := strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil || value <= 0 {
+			slog.Warn("Invalid value for THUMBNAIL_CACHE_SIZE environment variable", "value", sizeStr, "err", err)
+		} else {
+			thumbnailCacheSize = value << 20
+		}
+	}
+
+	if err = services.InitThumbnailCache(thumbnailCacheDir, thumbnailCacheSize); err != nil {
+		panic(err)
+	}
+
+	coverDir := services.DefaultCoverDir
+	if dir, ok := os.LookupEnv("COVER_DIR"); ok {
+		coverDir = dir
+	}
+
+	if err = services.InitCoverDir(coverDir); err != nil {
+		panic(err)
+	}
+
+	mediaCheckInterval := services.DefaultMediaCheckInterval
+	if intervalStr, ok := os.LookupEnv("MEDIA_CHECK_INTERVAL"); ok {
+		value, err := time.ParseDuration(intervalStr)
+		if err != nil {
+			slog.Warn("Invalid value for MEDIA_CHECK_INTERVAL environment variable", "value", intervalStr, "err", err)
+		} else {
+			mediaCheckInterval = value
+		}
+	}
+
+	mediaRecheckAge := services.DefaultMediaRecheckAge
+	if ageStr, ok := os.LookupEnv("MEDIA_RECHECK_AGE"); ok {
+		value, err := time.ParseDuration(ageStr)
+		if err != nil {
+			slog.Warn("Invalid value for MEDIA_RECHECK_AGE environment variable", "value", ageStr, "err", err)
+		} else {
+			mediaRecheckAge = value
+		}
+	}
+	services.StartMediaChecker(mediaCheckInterval, mediaRecheckAge)
+
 	addr, ok := os.LookupEnv("PLST4_ADDR")
 	if !ok {
 		addr = "localhost:6972"
@@ -0,0 +1 @@
+ALTER TABLE medias ADD COLUMN permalink VARCHAR(255);
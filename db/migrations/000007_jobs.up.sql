@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS jobs(
+  id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  playlist INT NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+  username VARCHAR(50) NOT NULL REFERENCES users(username),
+  socket_id VARCHAR(32), -- WebSocket that requested the job, if any
+  position VARCHAR(20) NOT NULL,
+  status VARCHAR(10) NOT NULL DEFAULT 'pending',
+  created_timestamp TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_timestamp TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- URLs to add, in order. Media lists are expanded into one row per entry
+-- (sub_index) once resolved.
+CREATE TABLE IF NOT EXISTS job_items(
+  job INT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+  item_index INT NOT NULL,
+  sub_index INT NOT NULL DEFAULT 0,
+  url TEXT NOT NULL,
+  list_mode VARCHAR(10) NOT NULL DEFAULT '',
+  media INT REFERENCES medias(id),
+  error TEXT,
+  PRIMARY KEY (job, item_index, sub_index)
+);
+
+CREATE INDEX idx_job_status ON jobs(status);
+CREATE INDEX idx_job_playlist ON jobs(playlist, status);
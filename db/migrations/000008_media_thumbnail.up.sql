@@ -0,0 +1,6 @@
+ALTER TABLE medias ADD COLUMN thumbnail TEXT;
+
+-- thumbnails used to be derived from YouTube URLs only
+UPDATE medias
+SET thumbnail = 'https://i3.ytimg.com/vi/' || SUBSTRING(url FROM LENGTH('https://youtu.be/') + 1) || '/maxresdefault.jpg'
+WHERE url LIKE 'https://youtu.be/%';
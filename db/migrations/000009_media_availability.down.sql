@@ -0,0 +1,4 @@
+DROP INDEX idx_media_last_checked;
+ALTER TABLE medias DROP COLUMN last_checked;
+ALTER TABLE medias DROP COLUMN status_reason;
+ALTER TABLE medias DROP COLUMN status;
@@ -0,0 +1,5 @@
+ALTER TABLE medias ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'unknown';
+ALTER TABLE medias ADD COLUMN status_reason TEXT;
+ALTER TABLE medias ADD COLUMN last_checked TIMESTAMP;
+
+CREATE INDEX idx_media_last_checked ON medias(last_checked NULLS FIRST);
@@ -0,0 +1,2 @@
+ALTER TABLE playlist_items DROP COLUMN end_offset;
+ALTER TABLE playlist_items DROP COLUMN start_offset;
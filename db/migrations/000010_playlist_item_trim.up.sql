@@ -0,0 +1,3 @@
+-- offsets in seconds, NULL meaning the start/end of the media
+ALTER TABLE playlist_items ADD COLUMN start_offset INT;
+ALTER TABLE playlist_items ADD COLUMN end_offset INT;
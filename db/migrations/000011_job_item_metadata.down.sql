@@ -0,0 +1,4 @@
+ALTER TABLE job_items DROP COLUMN end_offset;
+ALTER TABLE job_items DROP COLUMN start_offset;
+ALTER TABLE job_items DROP COLUMN alt_artist;
+ALTER TABLE job_items DROP COLUMN alt_title;
@@ -0,0 +1,5 @@
+-- metadata restored from imported playlists, applied to the added items
+ALTER TABLE job_items ADD COLUMN alt_title VARCHAR(255);
+ALTER TABLE job_items ADD COLUMN alt_artist VARCHAR(255);
+ALTER TABLE job_items ADD COLUMN start_offset INT;
+ALTER TABLE job_items ADD COLUMN end_offset INT;
@@ -0,0 +1,3 @@
+-- private playlists are only visible to their owner and managers, unlisted
+-- ones are hidden from search
+ALTER TABLE playlists ADD COLUMN visibility VARCHAR(10) NOT NULL DEFAULT 'public';
@@ -0,0 +1,2 @@
+ALTER TABLE playlists DROP COLUMN access_code_version;
+ALTER TABLE playlists DROP COLUMN access_code_hash;
@@ -0,0 +1,4 @@
+-- bcrypt hash of the access code, NULL if the playlist needs none
+ALTER TABLE playlists ADD COLUMN access_code_hash VARCHAR(255);
+-- bumped whenever the access code changes, invalidating access cookies
+ALTER TABLE playlists ADD COLUMN access_code_version INT NOT NULL DEFAULT 0;
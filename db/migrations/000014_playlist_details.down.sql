@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS playlist_tags;
+ALTER TABLE playlists DROP COLUMN cover_media;
+ALTER TABLE playlists DROP COLUMN cover_file;
+ALTER TABLE playlists DROP COLUMN description;
@@ -0,0 +1,13 @@
+ALTER TABLE playlists ADD COLUMN description TEXT NOT NULL DEFAULT '';
+-- cover image, either a file in the cover directory or the thumbnail of a
+-- media, the current media is shown if neither is set
+ALTER TABLE playlists ADD COLUMN cover_file VARCHAR(255);
+ALTER TABLE playlists ADD COLUMN cover_media INT REFERENCES medias(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS playlist_tags(
+  playlist INT NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+  tag VARCHAR(50) NOT NULL,
+  PRIMARY KEY (playlist, tag)
+);
+
+CREATE INDEX idx_playlist_tags_tag ON playlist_tags(tag);
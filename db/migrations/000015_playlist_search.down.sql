@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS idx_playlists_created_timestamp;
+DROP INDEX IF EXISTS idx_playlists_last_played;
+ALTER TABLE playlists DROP COLUMN last_played;
+
+DROP INDEX IF EXISTS idx_alt_metadata_search_vector;
+ALTER TABLE alt_metadata DROP COLUMN search_vector;
+
+DROP INDEX IF EXISTS idx_medias_search_vector;
+ALTER TABLE medias DROP COLUMN search_vector;
+
+DROP INDEX IF EXISTS idx_playlists_name_trgm;
+DROP INDEX IF EXISTS idx_playlists_search_vector;
+ALTER TABLE playlists DROP COLUMN search_vector;
+
+DROP EXTENSION IF EXISTS pg_trgm;
@@ -0,0 +1,24 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- words of playlist names rank above those of descriptions
+ALTER TABLE playlists ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+  setweight(to_tsvector('simple', name), 'A') || setweight(to_tsvector('simple', description), 'B')
+) STORED;
+CREATE INDEX idx_playlists_search_vector ON playlists USING GIN (search_vector);
+-- substring matches of playlist names
+CREATE INDEX idx_playlists_name_trgm ON playlists USING GIN (LOWER(name) gin_trgm_ops);
+
+ALTER TABLE medias ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+  to_tsvector('simple', title || ' ' || artist)
+) STORED;
+CREATE INDEX idx_medias_search_vector ON medias USING GIN (search_vector);
+
+ALTER TABLE alt_metadata ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+  to_tsvector('simple', COALESCE(alt_title, '') || ' ' || COALESCE(alt_artist, ''))
+) STORED;
+CREATE INDEX idx_alt_metadata_search_vector ON alt_metadata USING GIN (search_vector);
+
+-- set whenever the current media changes
+ALTER TABLE playlists ADD COLUMN last_played TIMESTAMP;
+CREATE INDEX idx_playlists_last_played ON playlists (last_played DESC NULLS LAST);
+CREATE INDEX idx_playlists_created_timestamp ON playlists (created_timestamp);
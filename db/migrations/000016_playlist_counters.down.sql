@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_playlists_item_count;
+ALTER TABLE playlists DROP COLUMN total_duration;
+ALTER TABLE playlists DROP COLUMN item_count;
@@ -0,0 +1,15 @@
+-- kept up to date by the services writing playlist items, total_duration is
+-- the sum of the trimmed lengths of the items in seconds
+ALTER TABLE playlists ADD COLUMN item_count INT NOT NULL DEFAULT 0;
+ALTER TABLE playlists ADD COLUMN total_duration INT NOT NULL DEFAULT 0;
+
+UPDATE playlists p SET
+  item_count = (SELECT COUNT(*) FROM playlist_items i WHERE i.playlist = p.id),
+  total_duration = (
+    SELECT COALESCE(SUM(GREATEST(COALESCE(i.end_offset, m.duration) - COALESCE(i.start_offset, 0), 0)), 0)
+    FROM playlist_items i
+    JOIN medias m ON m.id = i.media
+    WHERE i.playlist = p.id
+  );
+
+CREATE INDEX idx_playlists_item_count ON playlists(item_count DESC);
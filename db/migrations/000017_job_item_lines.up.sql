@@ -0,0 +1,2 @@
+-- line of the URL in the batch it was read from, if any
+ALTER TABLE job_items ADD COLUMN line INT;
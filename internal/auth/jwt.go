@@ -3,6 +3,7 @@ package auth
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -35,3 +36,42 @@ func Authorize(username string, timeout time.Duration) (string, error) {
 	})
 	return token.SignedString([]byte(jwtSecret))
 }
+
+type PlaylistAccessClaims struct {
+	jwt.RegisteredClaims
+	// access code version of the playlist the token was issued for
+	Version int `json:"ver"`
+}
+
+// Access tokens are signed with their own key, so that they cannot be passed
+// off as login tokens.
+func playlistAccessKeyFunc(_ *jwt.Token) (interface{}, error) {
+	return []byte("playlist-access:" + jwtSecret), nil
+}
+
+func AuthorizePlaylistAccess(playlist, version int, timeout time.Duration) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &PlaylistAccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "plst4-web",
+			Subject:   strconv.Itoa(playlist),
+			Audience:  []string{"plst4.dev"},
+			ExpiresAt: &jwt.NumericDate{Time: now.Add(timeout)},
+			IssuedAt:  &jwt.NumericDate{Time: now},
+		},
+		Version: version,
+	})
+	key, _ := playlistAccessKeyFunc(token)
+	return token.SignedString(key)
+}
+
+// Get the access code version a token grants access to playlist for.
+func ParsePlaylistAccess(tokenStr string, playlist int) (version int, ok bool) {
+	claims := &PlaylistAccessClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, playlistAccessKeyFunc, jwt.WithSubject(strconv.Itoa(playlist)), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return 0, false
+	}
+
+	return claims.Version, true
+}
@@ -0,0 +1,20 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// Sign value for the given purpose, so that it can be handed to clients and
+// checked when they send it back. Signatures of different purposes are not
+// interchangeable.
+func Sign(purpose, value string) string {
+	mac := hmac.New(sha256.New, []byte(purpose+":"+jwtSecret))
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func CheckSignature(purpose, value, signature string) bool {
+	return hmac.Equal([]byte(Sign(purpose, value)), []byte(signature))
+}
@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"html/template"
 	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
 	"time"
 
 	"github.com/btmxh/plst4/internal/stores"
@@ -78,7 +82,38 @@ func DefaultFuncMap() template.FuncMap {
 	}
 }
 
+var rootDirOnce sync.Once
+
+// Templates are loaded relative to the repository root, while tests run in
+// their package directory, so tests enter the root first.
+func enterRootDir() {
+	rootDirOnce.Do(func() {
+		if !testing.Testing() {
+			return
+		}
+
+		dir, err := os.Getwd()
+		if err != nil {
+			return
+		}
+
+		for {
+			if info, err := os.Stat(filepath.Join(dir, "templates")); err == nil && info.IsDir() {
+				os.Chdir(dir)
+				return
+			}
+
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return
+			}
+			dir = parent
+		}
+	})
+}
+
 func GetTemplate(name string, paths ...string) *template.Template {
+	enterRootDir()
 	paths = append(paths, "templates/layout.tmpl")
 	return template.Must(template.New(name).Funcs(DefaultFuncMap()).ParseFiles(paths...))
 }
@@ -7,7 +7,12 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-var toastTemplate = template.Must(template.ParseFiles("templates/notifications/toast.tmpl"))
+var toastTemplate = parseToastTemplate()
+
+func parseToastTemplate() *template.Template {
+	enterRootDir()
+	return template.Must(template.ParseFiles("templates/notifications/toast.tmpl"))
+}
 
 type ToastKind string
 
@@ -0,0 +1,36 @@
+package media
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error messages (mostly from yt-dlp) meaning that a media is gone for good,
+// as opposed to transient failures like network errors or rate limits.
+var unavailableErrorPatterns = []string{
+	"video unavailable",
+	"private video",
+	"has been removed",
+	"no longer available",
+	"has been terminated",
+	"does not exist",
+	"http error 404",
+	"http error 410",
+}
+
+// Check whether err (returned while resolving a media) means that the media
+// is no longer available.
+func IsUnavailableError(err error) bool {
+	if errors.Is(err, ErrMediaNotFound) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range unavailableErrorPatterns {
+		if strings.Contains(msg, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
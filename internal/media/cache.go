@@ -0,0 +1,159 @@
+package media
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const DefaultResolveCacheTTL = time.Hour
+
+type resolveCacheEntry struct {
+	object    ResolvedMediaObject
+	expiresAt time.Time
+}
+
+// In-memory cache of resolved media objects (including media lists), keyed by
+// canonical URL. Concurrent resolves of the same URL share a single call.
+type ResolveCache struct {
+	ttl       time.Duration
+	entries   map[string]resolveCacheEntry
+	lastSweep time.Time
+	mutex     sync.Mutex
+	group     singleflight.Group
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+}
+
+type ResolveCacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+func NewResolveCache(ttl time.Duration) *ResolveCache {
+	return &ResolveCache{ttl: ttl, entries: make(map[string]resolveCacheEntry)}
+}
+
+var resolveCache = NewResolveCache(DefaultResolveCacheTTL)
+
+func SetResolveCacheTTL(ttl time.Duration) {
+	resolveCache = NewResolveCache(ttl)
+}
+
+func (c *ResolveCache) get(key string) (ResolvedMediaObject, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return entry.object, true
+}
+
+func (c *ResolveCache) set(key string, object ResolvedMediaObject) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	c.entries[key] = resolveCacheEntry{object: object, expiresAt: now.Add(c.ttl)}
+
+	// expired entries are only removed lazily on lookup, so sweep them once
+	// in a while to keep memory bounded
+	if now.Sub(c.lastSweep) > c.ttl {
+		for key, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		c.lastSweep = now
+	}
+}
+
+func (c *ResolveCache) Invalidate(m CanonicalizedMediaObject) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, m.URL().String())
+}
+
+func (c *ResolveCache) Resolve(ctx context.Context, m CanonicalizedMediaObject) (ResolvedMediaObject, error) {
+	key := m.URL().String()
+	if object, ok := c.get(key); ok {
+		c.hits.Add(1)
+		return object, nil
+	}
+
+	c.misses.Add(1)
+	// the call is shared by all callers, so it must not be cancelled with the
+	// first of them, callers only stop waiting for it
+	sharedCtx := context.WithoutCancel(ctx)
+	result := c.group.DoChan(key, func() (interface{}, error) {
+		var object ResolvedMediaObject
+		err := resolverPool.Do(sharedCtx, func(ctx context.Context) (err error) {
+			object, err = m.Resolve(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		c.set(key, object)
+		// entries of lists are usually resolved along with the list
+		for _, entry := range object.ChildEntries() {
+			c.set(entry.URL().String(), entry)
+		}
+
+		return object, nil
+	})
+
+	var object interface{}
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		object = res.Val
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return object.(ResolvedMediaObject), nil
+}
+
+func (c *ResolveCache) Stats() ResolveCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return ResolveCacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: len(c.entries),
+	}
+}
+
+// Resolve m, reusing recently resolved objects with the same canonical URL.
+func Resolve(ctx context.Context, m CanonicalizedMediaObject) (ResolvedMediaObject, error) {
+	return resolveCache.Resolve(ctx, m)
+}
+
+// Resolve m, bypassing (but updating) the cache, e.g. when refreshing
+// metadata of a media.
+func ResolveFresh(ctx context.Context, m CanonicalizedMediaObject) (ResolvedMediaObject, error) {
+	resolveCache.Invalidate(m)
+	return resolveCache.Resolve(ctx, m)
+}
+
+func GetResolveCacheStats() ResolveCacheStats {
+	return resolveCache.Stats()
+}
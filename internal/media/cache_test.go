@@ -0,0 +1,105 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Media whose resolution blocks until released or cancelled.
+type blockingMedia struct {
+	url     *url.URL
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+type blockingMediaResolved struct {
+	*blockingMedia
+}
+
+func (m *blockingMedia) Kind() MediaKind {
+	return MediaKindTestVideo
+}
+
+func (m *blockingMedia) Canonicalize(ctx context.Context) (CanonicalizedMediaObject, error) {
+	return m, nil
+}
+
+func (m *blockingMedia) URL() *url.URL {
+	return m.url
+}
+
+func (m *blockingMedia) Resolve(ctx context.Context) (ResolvedMediaObject, error) {
+	m.calls.Add(1)
+	select {
+	case <-m.release:
+		return blockingMediaResolved{m}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m blockingMediaResolved) Title() string {
+	return "title"
+}
+
+func (m blockingMediaResolved) Artist() string {
+	return "artist"
+}
+
+func (m blockingMediaResolved) ChildEntries() []ResolvedMediaObjectSingle {
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResolveCacheCancelledCallerDoesNotFailOthers(t *testing.T) {
+	cache := NewResolveCache(time.Minute)
+	m := &blockingMedia{url: &url.URL{Scheme: "https", Host: "example.com", Path: "/media"}, release: make(chan struct{})}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Resolve(firstCtx, m)
+		firstErr <- err
+	}()
+	waitFor(t, func() bool { return m.calls.Load() == 1 })
+
+	secondErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Resolve(context.Background(), m)
+		secondErr <- err
+	}()
+	waitFor(t, func() bool { return cache.Stats().Misses == 2 })
+	time.Sleep(10 * time.Millisecond)
+
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first caller: expected context.Canceled, got %v", err)
+	}
+
+	close(m.release)
+	if err := <-secondErr; err != nil {
+		t.Fatalf("second caller: %v", err)
+	}
+
+	if calls := m.calls.Load(); calls != 1 {
+		t.Errorf("expected a single resolve, got %d", calls)
+	}
+
+	if _, err := cache.Resolve(context.Background(), m); err != nil || cache.Stats().Hits != 1 {
+		t.Errorf("expected a cache hit, got err %v and stats %+v", err, cache.Stats())
+	}
+}
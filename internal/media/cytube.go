@@ -0,0 +1,50 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnsupportedCytubeMedia = errors.New("Media type not supported by plst4")
+var ErrInvalidCytubeMedia = errors.New("Invalid cytube media")
+
+// A cytube playlist entry mapped onto plst4.
+type CytubeMedia struct {
+	URL       string
+	Kind      MediaKind
+	Thumbnail string
+}
+
+func unsupportedCytubeMedia(url, name string) (CytubeMedia, error) {
+	return CytubeMedia{URL: url}, fmt.Errorf("%w: %s", ErrUnsupportedCytubeMedia, name)
+}
+
+// Map a media of a cytube playlist, given by its cytube type and ID. The URL
+// is set even for unsupported media, so that they can be reported.
+func MapCytubeMedia(cytubeType, id string) (CytubeMedia, error) {
+	switch cytubeType {
+	case "yt":
+		if !checkVideoId(id) {
+			return CytubeMedia{URL: id}, fmt.Errorf("%w: YouTube video ID %s", ErrInvalidCytubeMedia, id)
+		}
+
+		return CytubeMedia{
+			URL:       videoURL(id).String(),
+			Kind:      MediaKindYoutube,
+			Thumbnail: "https://i3.ytimg.com/vi/" + id + "/maxresdefault.jpg",
+		}, nil
+	case "sc":
+		// cytube uses track permalinks as IDs
+		return CytubeMedia{URL: id, Kind: MediaKindSoundcloud}, nil
+	case "vi":
+		return unsupportedCytubeMedia("https://vimeo.com/"+id, "Vimeo")
+	case "dm":
+		return unsupportedCytubeMedia("https://www.dailymotion.com/video/"+id, "Dailymotion")
+	case "tw":
+		return unsupportedCytubeMedia("https://www.twitch.tv/"+id, "Twitch")
+	case "fi":
+		return unsupportedCytubeMedia(id, "raw file")
+	default:
+		return unsupportedCytubeMedia(id, fmt.Sprintf("cytube type %q", cytubeType))
+	}
+}
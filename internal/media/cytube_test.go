@@ -0,0 +1,34 @@
+package media
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMapCytubeMedia(t *testing.T) {
+	tests := []struct {
+		cytubeType, id string
+		expected       CytubeMedia
+		err            error
+	}{
+		{"yt", "dQw4w9WgXcQ", CytubeMedia{URL: "https://youtu.be/dQw4w9WgXcQ", Kind: MediaKindYoutube, Thumbnail: "https://i3.ytimg.com/vi/dQw4w9WgXcQ/maxresdefault.jpg"}, nil},
+		{"yt", "not-an-id", CytubeMedia{URL: "not-an-id"}, ErrInvalidCytubeMedia},
+		{"sc", "https://soundcloud.com/artist/track", CytubeMedia{URL: "https://soundcloud.com/artist/track", Kind: MediaKindSoundcloud}, nil},
+		{"vi", "123456", CytubeMedia{URL: "https://vimeo.com/123456"}, ErrUnsupportedCytubeMedia},
+		{"dm", "x7abc", CytubeMedia{URL: "https://www.dailymotion.com/video/x7abc"}, ErrUnsupportedCytubeMedia},
+		{"tw", "channel", CytubeMedia{URL: "https://www.twitch.tv/channel"}, ErrUnsupportedCytubeMedia},
+		{"fi", "https://example.com/a.mp4", CytubeMedia{URL: "https://example.com/a.mp4"}, ErrUnsupportedCytubeMedia},
+		{"xx", "id", CytubeMedia{URL: "id"}, ErrUnsupportedCytubeMedia},
+	}
+
+	for _, test := range tests {
+		mapped, err := MapCytubeMedia(test.cytubeType, test.id)
+		if !errors.Is(err, test.err) {
+			t.Errorf("MapCytubeMedia(%q, %q): expected error %v, got %v", test.cytubeType, test.id, test.err, err)
+		}
+
+		if mapped != test.expected {
+			t.Errorf("MapCytubeMedia(%q, %q) = %+v, expected %+v", test.cytubeType, test.id, mapped, test.expected)
+		}
+	}
+}
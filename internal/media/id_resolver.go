@@ -20,6 +20,8 @@ type IdMediaObjectResolveInfo struct {
 	artist      string
 	length      time.Duration
 	aspectRatio string
+	permalink   string
+	thumbnail   string
 }
 
 type IdMediaListObjectResolveInfo[ID any] struct {
@@ -53,6 +55,10 @@ func (m *IdMediaObject[ID]) URL() *url.URL {
 }
 
 func (m *IdMediaObject[ID]) Resolve(ctx context.Context) (ResolvedMediaObject, error) {
+	if m.resolveInfo != nil {
+		return m, nil
+	}
+
 	return m.source.ResolveMedia(ctx, m.id)
 }
 
@@ -72,6 +78,14 @@ func (m *IdMediaObject[ID]) AspectRatio() string {
 	return m.resolveInfo.aspectRatio
 }
 
+func (m *IdMediaObject[ID]) Permalink() string {
+	return m.resolveInfo.permalink
+}
+
+func (m *IdMediaObject[ID]) Thumbnail() string {
+	return m.resolveInfo.thumbnail
+}
+
 func (m *IdMediaObject[ID]) ChildEntries() []ResolvedMediaObjectSingle {
 	return nil
 }
@@ -15,6 +15,7 @@ const (
 	MediaKindTestAudio MediaKind = "testaudio"
 	UnknownTitle                 = "Unknown title"
 	UnknownArtist                = "Unknown artist"
+	DefaultThumbnail             = "/assets/local.svg"
 )
 
 var ErrUnsupportedURL = errors.New("Unsupported URL")
@@ -46,12 +47,45 @@ type ResolvedMediaObjectSingle interface {
 
 	Duration() time.Duration
 	AspectRatio() string
+	// URL of the thumbnail image, empty if there is none
+	Thumbnail() string
+}
+
+// Implemented by media whose canonical URL is not suitable for display, e.g.
+// SoundCloud tracks, which are keyed by their numeric track ID.
+type PermalinkedMediaObject interface {
+	Permalink() string
+}
+
+// Implemented by URLs referring to both a single media and a media list
+// containing it, e.g. YouTube watch URLs with a list parameter. Canonicalize
+// picks the single media.
+type MediaObjectWithList interface {
+	MediaObject
+
+	SingleMedia() MediaObject
+	MediaList() MediaObject
 }
 
 type MediaSource interface {
 	ProcessURL(u *url.URL) (MediaObject, error)
 }
 
+type SearchResult struct {
+	URL       *url.URL
+	Title     string
+	Artist    string
+	Duration  time.Duration
+	Thumbnail string
+}
+
+type SearchableMediaSource interface {
+	MediaSource
+
+	Kind() MediaKind
+	Search(ctx context.Context, query string, limit int) ([]SearchResult, error)
+}
+
 func firstNonEmpty(values ...string) string {
 	for _, value := range values {
 		if value != "" {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/wader/goutubedl"
@@ -13,19 +14,21 @@ const MediaKindNiconico MediaKind = "2525"
 
 var ErrInvalidNiconicoURL = errors.New("Invalid Niconico URL")
 
+var niconicoNumericIdRegex = regexp.MustCompile("^[0-9]+$")
+
 func NewNiconicoYtdlResolver() *YtdlResolver {
 	return &YtdlResolver{
 		mediaUrlPattern:     "https://www.nicovideo.jp/watch/%s",
-		mediaListUrlPattern: "",
-		searchPrefix:        "nicosearch1:",
-		// this is not really used as media lists are currently unsupported
+		mediaListUrlPattern: "https://www.nicovideo.jp/%s",
+		searchPrefix:        "nicosearch",
+		// flat playlist entries only have url set, not webpage_url
 		idExtractor: func(info goutubedl.Info) string {
-			id := strings.TrimPrefix(info.WebpageURL, "https://www.nicovideo.jp/watch/")
-			if id == info.WebpageURL {
-				panic("incomplete niconico info")
+			u := firstNonEmpty(info.WebpageURL, info.URL)
+			if id, found := strings.CutPrefix(u, "https://www.nicovideo.jp/watch/"); found {
+				return id
 			}
 
-			return id
+			return info.ID
 		},
 	}
 }
@@ -47,7 +50,7 @@ func (nc *NiconicoSource) MediaURL(id string) *url.URL {
 }
 
 func (nc *NiconicoSource) MediaListURL(id string) *url.URL {
-	panic("unsupported")
+	return nc.resolver.MediaListURL(id)
 }
 
 func (nc *NiconicoSource) ResolveMedia(ctx context.Context, id string) (ResolvedMediaObjectSingle, error) {
@@ -55,7 +58,11 @@ func (nc *NiconicoSource) ResolveMedia(ctx context.Context, id string) (Resolved
 }
 
 func (nc *NiconicoSource) ResolveMediaList(ctx context.Context, id string) (ResolvedMediaObject, error) {
-	panic("unsupported")
+	return nc.resolver.ResolveMediaList(nc, ctx, id)
+}
+
+func (nc *NiconicoSource) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	return nc.resolver.Search(nc, ctx, query, limit)
 }
 
 func (nc *NiconicoSource) ProcessURL(u *url.URL) (MediaObject, error) {
@@ -77,5 +84,40 @@ func (nc *NiconicoSource) ProcessURL(u *url.URL) (MediaObject, error) {
 		return NewIdMediaObject(nc, id, nil), nil
 	}
 
+	if id, found := niconicoListId(strings.TrimSuffix(path, "/")); found {
+		return NewIdMediaListObject(nc, id, nil), nil
+	}
+
 	return nil, ErrInvalidNiconicoURL
 }
+
+// niconicoListId maps mylist, series and user upload paths to the path of
+// their canonical list URL, relative to https://www.nicovideo.jp/
+func niconicoListId(path string) (id string, found bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) >= 2 && parts[0] == "user" {
+		if !niconicoNumericIdRegex.MatchString(parts[1]) {
+			return "", false
+		}
+
+		// user/<uid>/mylist/<id> and user/<uid>/series/<id>
+		if len(parts) == 4 {
+			parts = parts[2:]
+		} else if len(parts) == 2 || (len(parts) == 3 && parts[2] == "video") {
+			return "user/" + parts[1] + "/video", true
+		} else {
+			return "", false
+		}
+	}
+
+	if len(parts) != 2 || !niconicoNumericIdRegex.MatchString(parts[1]) {
+		return "", false
+	}
+
+	switch parts[0] {
+	case "mylist", "series":
+		return parts[0] + "/" + parts[1], true
+	default:
+		return "", false
+	}
+}
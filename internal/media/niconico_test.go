@@ -0,0 +1,32 @@
+package media
+
+import "testing"
+
+func TestNiconicoListId(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		found    bool
+	}{
+		{"mylist/123", "mylist/123", true},
+		{"series/456", "series/456", true},
+		{"user/789", "user/789/video", true},
+		{"user/789/video", "user/789/video", true},
+		{"user/789/mylist/123", "mylist/123", true},
+		{"user/789/series/456", "series/456", true},
+		{"mylist/abc", "", false},
+		{"mylist", "", false},
+		{"tag/music", "", false},
+		{"user/abc", "", false},
+		{"user/789/follow", "", false},
+		{"user/789/mylist/abc", "", false},
+		{"user/789/tag/123", "", false},
+	}
+
+	for _, test := range tests {
+		id, found := niconicoListId(test.path)
+		if id != test.expected || found != test.found {
+			t.Errorf("niconicoListId(%q) = %q, %v, expected %q, %v", test.path, id, found, test.expected, test.found)
+		}
+	}
+}
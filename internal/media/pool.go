@@ -0,0 +1,52 @@
+package media
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DefaultResolverConcurrency = 4
+	DefaultResolveTimeout      = 2 * time.Minute
+)
+
+// Limits the number of concurrent resolves (i.e. yt-dlp processes and API
+// calls), and gives each of them a deadline.
+type ResolverPool struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func NewResolverPool(concurrency int, timeout time.Duration) *ResolverPool {
+	return &ResolverPool{slots: make(chan struct{}, concurrency), timeout: timeout}
+}
+
+var resolverPool = NewResolverPool(DefaultResolverConcurrency, DefaultResolveTimeout)
+
+func ConfigureResolverPool(concurrency int, timeout time.Duration) {
+	resolverPool = NewResolverPool(concurrency, timeout)
+}
+
+// Run fn once a slot is free, giving up if ctx is done before that.
+func (p *ResolverPool) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	return fn(ctx)
+}
+
+// Canonicalize m in the resolver pool.
+func Canonicalize(ctx context.Context, m MediaObject) (canonMedia CanonicalizedMediaObject, err error) {
+	err = resolverPool.Do(ctx, func(ctx context.Context) error {
+		canonMedia, err = m.Canonicalize(ctx)
+		return err
+	})
+	return canonMedia, err
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/wader/goutubedl"
@@ -13,21 +15,55 @@ const MediaKindSoundcloud MediaKind = "sc"
 
 var ErrInvalidSCURL = errors.New("Invalid SoundCloud URL")
 
+// user pages that are not tracks
+var soundcloudReservedPaths = []string{"sets", "tracks", "albums", "likes", "reposts", "popular-tracks", "followers", "following", "comments"}
+
+var soundcloudTrackIdRegex = regexp.MustCompile("^[0-9]+$")
+
+// Tracks are identified by their numeric track ID, since artist/slug
+// permalinks change whenever a track is renamed.
 func NewSoundcloudYtdlResolver() *YtdlResolver {
 	return &YtdlResolver{
-		mediaUrlPattern:     "https://soundcloud.com/%s",
-		mediaListUrlPattern: "",
-		searchPrefix:        "scsearch1:",
-		// this is not really used as media lists are currently unsupported
+		mediaUrlPattern:     "https://api.soundcloud.com/tracks/%s",
+		mediaListUrlPattern: "https://soundcloud.com/%s",
+		searchPrefix:        "scsearch",
 		idExtractor: func(info goutubedl.Info) string {
-			id := strings.TrimPrefix(info.WebpageURL, "https://soundcloud.com/")
-			if id == info.WebpageURL {
-				panic("incomplete soundcloud info")
+			if !soundcloudTrackIdRegex.MatchString(info.ID) {
+				return ""
 			}
 
-			return id
+			return info.ID
 		},
+		// flat playlist entries only have url set, not webpage_url
+		permalinkExtractor: func(info goutubedl.Info) string {
+			u := firstNonEmpty(info.WebpageURL, info.URL)
+			if !strings.HasPrefix(u, "https://soundcloud.com/") {
+				return ""
+			}
+
+			return u
+		},
+	}
+}
+
+// A track referred to by its artist/slug permalink, canonicalized by looking
+// up its track ID.
+type SoundcloudPermalink struct {
+	source *SoundcloudSource
+	path   string
+}
+
+func (p *SoundcloudPermalink) Kind() MediaKind {
+	return MediaKindSoundcloud
+}
+
+func (p *SoundcloudPermalink) Canonicalize(ctx context.Context) (CanonicalizedMediaObject, error) {
+	u, err := url.Parse("https://soundcloud.com/" + p.path)
+	if err != nil {
+		return nil, err
 	}
+
+	return p.source.resolver.ResolveMediaFromURL(p.source, ctx, u)
 }
 
 type SoundcloudSource struct {
@@ -47,7 +83,7 @@ func (sc *SoundcloudSource) MediaURL(id string) *url.URL {
 }
 
 func (sc *SoundcloudSource) MediaListURL(id string) *url.URL {
-	panic("unsupported")
+	return sc.resolver.MediaListURL(id)
 }
 
 func (sc *SoundcloudSource) ResolveMedia(ctx context.Context, id string) (ResolvedMediaObjectSingle, error) {
@@ -55,7 +91,11 @@ func (sc *SoundcloudSource) ResolveMedia(ctx context.Context, id string) (Resolv
 }
 
 func (sc *SoundcloudSource) ResolveMediaList(ctx context.Context, id string) (ResolvedMediaObject, error) {
-	panic("unsupported")
+	return sc.resolver.ResolveMediaList(sc, ctx, id)
+}
+
+func (sc *SoundcloudSource) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	return sc.resolver.Search(sc, ctx, query, limit)
 }
 
 func (sc *SoundcloudSource) ProcessURL(u *url.URL) (MediaObject, error) {
@@ -69,13 +109,37 @@ func (sc *SoundcloudSource) ProcessURL(u *url.URL) (MediaObject, error) {
 		path = path[1:]
 	}
 
+	if u.Hostname() == "api.soundcloud.com" {
+		id, found := strings.CutPrefix(strings.TrimSuffix(path, "/"), "tracks/")
+		if !found || !soundcloudTrackIdRegex.MatchString(id) {
+			return nil, ErrInvalidSCURL
+		}
+
+		return NewIdMediaObject(sc, id, nil), nil
+	}
+
 	if u.Hostname() != "soundcloud.com" && u.Hostname() != "www.soundcloud.com" {
 		return nil, ErrUnsupportedURL
 	}
 
-	if len(strings.Split(path, "/")) != 2 {
+	path = strings.TrimSuffix(path, "/")
+	parts := strings.Split(path, "/")
+	if slices.Contains(parts, "") {
+		return nil, ErrInvalidSCURL
+	}
+
+	// <user>/tracks and <user>/sets/<set>
+	if len(parts) == 2 && parts[1] == "tracks" {
+		return NewIdMediaListObject(sc, path, nil), nil
+	}
+
+	if len(parts) == 3 && parts[1] == "sets" {
+		return NewIdMediaListObject(sc, path, nil), nil
+	}
+
+	if len(parts) != 2 || slices.Contains(soundcloudReservedPaths, parts[1]) {
 		return nil, ErrInvalidSCURL
 	}
 
-	return NewIdMediaObject(sc, path, nil), nil
+	return &SoundcloudPermalink{source: sc, path: path}, nil
 }
@@ -0,0 +1,75 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestSoundcloudProcessURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+		err      error
+	}{
+		{"https://soundcloud.com/artist/track", "permalink artist/track", nil},
+		{"https://www.soundcloud.com/artist/track/", "permalink artist/track", nil},
+		{"https://api.soundcloud.com/tracks/123456", "media 123456", nil},
+		{"https://soundcloud.com/artist/tracks", "list artist/tracks", nil},
+		{"https://soundcloud.com/artist/sets/album", "list artist/sets/album", nil},
+		{"https://api.soundcloud.com/tracks/slug", "", ErrInvalidSCURL},
+		{"https://soundcloud.com/artist", "", ErrInvalidSCURL},
+		{"https://soundcloud.com/artist/sets", "", ErrInvalidSCURL},
+		{"https://soundcloud.com/artist/likes", "", ErrInvalidSCURL},
+		{"https://soundcloud.com/artist/popular-tracks", "", ErrInvalidSCURL},
+		{"https://soundcloud.com/artist//track", "", ErrInvalidSCURL},
+		{"https://soundcloud.com/artist/track/comments", "", ErrInvalidSCURL},
+		{"https://example.com/artist/track", "", ErrUnsupportedURL},
+	}
+
+	sc := NewSoundcloud()
+	for _, test := range tests {
+		checkProcessURL(t, sc, test.url, test.expected, test.err)
+	}
+}
+
+// Check that source processes rawURL into the media object described by
+// expected (see describeMediaObject).
+func checkProcessURL(t *testing.T, source MediaSource, rawURL, expected string, expectedErr error) {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := source.ProcessURL(u)
+	if !errors.Is(err, expectedErr) {
+		t.Errorf("ProcessURL(%q): expected error %v, got %v", rawURL, expectedErr, err)
+		return
+	}
+
+	if err == nil && describeMediaObject(obj) != expected {
+		t.Errorf("ProcessURL(%q) = %s, expected %s", rawURL, describeMediaObject(obj), expected)
+	}
+}
+
+func describeMediaObject(obj MediaObject) string {
+	switch obj := obj.(type) {
+	case *IdMediaObject[string]:
+		return "media " + obj.id
+	case *IdMediaListObject[string]:
+		return "list " + obj.id
+	case *YoutubeVideoInList:
+		return describeMediaObject(obj.video) + " in " + describeMediaObject(obj.list)
+	case *YoutubeChannel:
+		return "channel " + obj.url.String()
+	case *YoutubeVideoQuery:
+		return "query " + obj.query
+	case *SoundcloudPermalink:
+		return "permalink " + obj.path
+	default:
+		return fmt.Sprintf("%T", obj)
+	}
+}
@@ -1,8 +1,13 @@
 package media
 
 import (
+	"context"
+	"log/slog"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,9 +15,52 @@ import (
 var mediaSources []MediaSource
 
 func InitMediaSources() {
+	ConfigureYtdl(os.Getenv("YTDLP_PATH"), strings.Fields(os.Getenv("YTDLP_ARGS")))
+
+	concurrency := DefaultResolverConcurrency
+	if concurrencyStr, ok := os.LookupEnv("RESOLVER_CONCURRENCY"); ok {
+		value, err := strconv.Atoi(concurrencyStr)
+		if err != nil || value <= 0 {
+			slog.Warn("Invalid value for RESOLVER_CONCURRENCY environment variable", "value", concurrencyStr, "err", err)
+		} else {
+			concurrency = value
+		}
+	}
+
+	timeout := DefaultResolveTimeout
+	if timeoutStr, ok := os.LookupEnv("RESOLVE_TIMEOUT"); ok {
+		value, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			slog.Warn("Invalid value for RESOLVE_TIMEOUT environment variable", "err", err)
+		} else {
+			timeout = value
+		}
+	}
+
+	ConfigureResolverPool(concurrency, timeout)
+
+	if ttlStr, ok := os.LookupEnv("MEDIA_CACHE_TTL"); ok {
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil {
+			slog.Warn("Invalid value for MEDIA_CACHE_TTL environment variable", "err", err)
+		} else {
+			SetResolveCacheTTL(ttl)
+		}
+	}
+
 	ytApiKey := os.Getenv("YOUTUBE_API_KEY")
 	if ytApiKey != "" {
-		mediaSources = append(mediaSources, NewYoutubeAPI(ytApiKey))
+		quotaBudget := DefaultYoutubeQuotaBudget
+		if budgetStr, ok := os.LookupEnv("YOUTUBE_API_QUOTA"); ok {
+			budget, err := strconv.Atoi(budgetStr)
+			if err != nil {
+				slog.Warn("Invalid value for YOUTUBE_API_QUOTA environment variable", "err", err)
+			} else {
+				quotaBudget = budget
+			}
+		}
+
+		mediaSources = append(mediaSources, NewYoutubeAPI(ytApiKey, os.Getenv("YOUTUBE_API_ENDPOINT"), quotaBudget))
 	}
 
 	mediaSources = append(mediaSources, NewYoutubeDL())
@@ -41,3 +89,19 @@ func ProcessURL(u string) (MediaObject, error) {
 
 	return nil, ErrUnsupportedURL
 }
+
+// Search for medias of the given kind with the first source supporting it.
+func Search(ctx context.Context, kind MediaKind, query string, limit int) ([]SearchResult, error) {
+	for _, source := range mediaSources {
+		if searchable, ok := source.(SearchableMediaSource); ok && searchable.Kind() == kind {
+			var results []SearchResult
+			err := resolverPool.Do(ctx, func(ctx context.Context) (err error) {
+				results, err = searchable.Search(ctx, query, limit)
+				return err
+			})
+			return results, err
+		}
+	}
+
+	return nil, ErrUnsupportedOperation
+}
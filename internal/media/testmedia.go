@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -26,6 +27,7 @@ type TestMediaInfo struct {
 	artist      string
 	length      time.Duration
 	aspectRatio string
+	thumbnail   string
 }
 
 type TestMedia struct {
@@ -87,6 +89,10 @@ func (m *TestMedia) AspectRatio() string {
 	return m.info.aspectRatio
 }
 
+func (m *TestMedia) Thumbnail() string {
+	return m.info.thumbnail
+}
+
 type TestMediaListInfo struct {
 	title  string
 	artist string
@@ -200,9 +206,11 @@ func resolveSingle(ctx context.Context, m *TestMedia) (*TestMedia, error) {
 
 	duration := info.Format.Duration()
 	aspectRatio := "16/9"
+	thumbnail := ""
 	videoStream := info.FirstVideoStream()
 	if videoStream != nil {
 		aspectRatio = fmt.Sprintf("%d/%d", int(videoStream.Width), int(videoStream.Height))
+		thumbnail = extractThumbnail(ctx, m, duration)
 	}
 
 	m.info = &TestMediaInfo{
@@ -210,11 +218,37 @@ func resolveSingle(ctx context.Context, m *TestMedia) (*TestMedia, error) {
 		artist:      artist,
 		length:      duration,
 		aspectRatio: aspectRatio,
+		thumbnail:   thumbnail,
 	}
 
 	return m, nil
 }
 
+// Extract a frame of the media (or its cover art) with ffmpeg, returning the
+// URL of the extracted image, or an empty string if that is not possible.
+func extractThumbnail(ctx context.Context, m *TestMedia, duration time.Duration) string {
+	thumbnailPath := filepath.Join("thumbnails", m.path+".jpg")
+	outputPath := filepath.Join("./www/testmedias", thumbnailPath)
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		slog.Warn("Unable to create test media thumbnail directory", "err", err)
+		return ""
+	}
+
+	seek := min(time.Second, duration/2)
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-loglevel", "error", "-y",
+		"-ss", fmt.Sprintf("%.3f", seek.Seconds()),
+		"-i", filepath.Join("./www/testmedias", m.path),
+		"-frames:v", "1", "-vf", "scale=320:-2", outputPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		slog.Warn("Unable to extract test media thumbnail", "path", m.path, "err", err, "output", string(output))
+		return ""
+	}
+
+	u := m.URL()
+	u.Path = filepath.Join("testmedias", thumbnailPath)
+	return u.String()
+}
+
 var ErrInvalidTestMediaURL = errors.New("Invalid test media URL")
 
 func (v *TestMediaResolver) ProcessURL(u *url.URL) (MediaObject, error) {
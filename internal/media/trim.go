@@ -0,0 +1,113 @@
+package media
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Part of a media to be played, zero values mean the start and the end of the
+// media respectively.
+type Trim struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+var ErrInvalidTrimOffset = errors.New("Invalid trim offset, expected seconds, 1h2m3s or 1:02:03.")
+var ErrInvalidTrim = errors.New("Trim end must be after trim start.")
+
+var unitOffsetPattern = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s)?$`)
+
+// Parse an offset given in seconds ("90"), with units ("1m30s", as in YouTube
+// t= parameters) or as a clock time ("1:30").
+func ParseTrimOffset(str string) (time.Duration, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, nil
+	}
+
+	if seconds, err := strconv.Atoi(str); err == nil {
+		if seconds < 0 {
+			return 0, ErrInvalidTrimOffset
+		}
+
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	if strings.Contains(str, ":") {
+		parts := strings.Split(str, ":")
+		if len(parts) > 3 {
+			return 0, ErrInvalidTrimOffset
+		}
+
+		var offset time.Duration
+		for _, part := range parts {
+			value, err := strconv.Atoi(part)
+			if err != nil || value < 0 {
+				return 0, ErrInvalidTrimOffset
+			}
+
+			offset = offset*60 + time.Duration(value)
+		}
+
+		return offset * time.Second, nil
+	}
+
+	match := unitOffsetPattern.FindStringSubmatch(str)
+	if match == nil {
+		return 0, ErrInvalidTrimOffset
+	}
+
+	var offset time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		if match[i+1] != "" {
+			value, _ := strconv.Atoi(match[i+1])
+			offset += time.Duration(value) * unit
+		}
+	}
+
+	return offset, nil
+}
+
+func NewTrim(start, end time.Duration) (Trim, error) {
+	if end != 0 && end <= start {
+		return Trim{}, ErrInvalidTrim
+	}
+
+	return Trim{Start: start, End: end}, nil
+}
+
+// Get the trim given by t=, start= and end= parameters of a media URL.
+// Malformed parameters are ignored.
+func ParseTrim(rawUrl string) Trim {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return Trim{}
+	}
+
+	query := u.Query()
+	startStr := query.Get("start")
+	if startStr == "" {
+		startStr = query.Get("t")
+	}
+
+	start, err := ParseTrimOffset(startStr)
+	if err != nil {
+		start = 0
+	}
+
+	end, err := ParseTrimOffset(query.Get("end"))
+	if err != nil {
+		end = 0
+	}
+
+	trim, err := NewTrim(start, end)
+	if err != nil {
+		return Trim{Start: start}
+	}
+
+	return trim
+}
@@ -0,0 +1,79 @@
+package media
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTrimOffset(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		err      error
+	}{
+		{"", 0, nil},
+		{"  ", 0, nil},
+		{"90", 90 * time.Second, nil},
+		{" 90 ", 90 * time.Second, nil},
+		{"1m30s", 90 * time.Second, nil},
+		{"1h2m3s", time.Hour + 2*time.Minute + 3*time.Second, nil},
+		{"2h", 2 * time.Hour, nil},
+		{"45s", 45 * time.Second, nil},
+		{"1:30", 90 * time.Second, nil},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second, nil},
+		{"-5", 0, ErrInvalidTrimOffset},
+		{"1:2:3:4", 0, ErrInvalidTrimOffset},
+		{"1:-2", 0, ErrInvalidTrimOffset},
+		{"1::2", 0, ErrInvalidTrimOffset},
+		{"1s2m", 0, ErrInvalidTrimOffset},
+		{"1.5", 0, ErrInvalidTrimOffset},
+		{"abc", 0, ErrInvalidTrimOffset},
+	}
+
+	for _, test := range tests {
+		offset, err := ParseTrimOffset(test.input)
+		if !errors.Is(err, test.err) {
+			t.Errorf("ParseTrimOffset(%q): expected error %v, got %v", test.input, test.err, err)
+		} else if offset != test.expected {
+			t.Errorf("ParseTrimOffset(%q) = %v, expected %v", test.input, offset, test.expected)
+		}
+	}
+}
+
+func TestNewTrim(t *testing.T) {
+	if trim, err := NewTrim(10*time.Second, 0); err != nil || trim != (Trim{Start: 10 * time.Second}) {
+		t.Errorf("expected trim without end to be valid, got %v, %v", trim, err)
+	}
+
+	for _, end := range []time.Duration{5 * time.Second, 10 * time.Second} {
+		if _, err := NewTrim(10*time.Second, end); !errors.Is(err, ErrInvalidTrim) {
+			t.Errorf("expected end %v before start to be invalid, got %v", end, err)
+		}
+	}
+}
+
+func TestParseTrim(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected Trim
+	}{
+		{"https://youtu.be/dQw4w9WgXcQ", Trim{}},
+		{"https://youtu.be/dQw4w9WgXcQ?t=42", Trim{Start: 42 * time.Second}},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1m30s", Trim{Start: 90 * time.Second}},
+		{"https://youtu.be/dQw4w9WgXcQ?start=10&end=1:00", Trim{Start: 10 * time.Second, End: time.Minute}},
+		// start= takes precedence over t=
+		{"https://youtu.be/dQw4w9WgXcQ?t=5&start=10", Trim{Start: 10 * time.Second}},
+		// malformed parameters are ignored
+		{"https://youtu.be/dQw4w9WgXcQ?t=soon&end=20", Trim{End: 20 * time.Second}},
+		// an end before the start is dropped
+		{"https://youtu.be/dQw4w9WgXcQ?start=30&end=20", Trim{Start: 30 * time.Second}},
+		{"://not a url", Trim{}},
+	}
+
+	for _, test := range tests {
+		if trim := ParseTrim(test.url); trim != test.expected {
+			t.Errorf("ParseTrim(%q) = %+v, expected %+v", test.url, trim, test.expected)
+		}
+	}
+}
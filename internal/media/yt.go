@@ -3,8 +3,10 @@ package media
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -17,7 +19,7 @@ func NewYoutubeYtdlResolver() *YtdlResolver {
 	return &YtdlResolver{
 		mediaUrlPattern:     "https://youtu.be/%s",
 		mediaListUrlPattern: "https://www.youtube.com/playlist?list=%s",
-		searchPrefix:        "ytsearch1:",
+		searchPrefix:        "ytsearch",
 	}
 }
 
@@ -84,27 +86,127 @@ func (v *YoutubeVideoQuery) Canonicalize(ctx context.Context) (CanonicalizedMedi
 	return v.source.resolver.SearchMedia(v.source, ctx, v.query)
 }
 
+// A channel referred to by its handle or custom URL, canonicalized to the
+// uploads playlist of the channel after looking up its channel ID.
+type YoutubeChannel struct {
+	source *YoutubeSource
+	url    *url.URL
+}
+
+func newYoutubeChannel(src *YoutubeSource, u *url.URL) *YoutubeChannel {
+	return &YoutubeChannel{source: src, url: u}
+}
+
+func (c *YoutubeChannel) Kind() MediaKind {
+	return MediaKindYoutube
+}
+
+func (c *YoutubeChannel) Canonicalize(ctx context.Context) (CanonicalizedMediaObject, error) {
+	channelId, err := c.source.resolver.ResolveChannelId(ctx, c.url)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadsId, ok := uploadsPlaylistId(channelId)
+	if !ok {
+		return nil, ErrMediaNotFound
+	}
+
+	return NewIdMediaListObject(c.source, uploadsId, nil), nil
+}
+
+// A watch URL with both a video and a playlist, e.g. watch?v=X&list=Y.
+type YoutubeVideoInList struct {
+	video MediaObject
+	list  MediaObject
+}
+
+func (v *YoutubeVideoInList) Kind() MediaKind {
+	return MediaKindYoutube
+}
+
+func (v *YoutubeVideoInList) Canonicalize(ctx context.Context) (CanonicalizedMediaObject, error) {
+	return v.video.Canonicalize(ctx)
+}
+
+func (v *YoutubeVideoInList) SingleMedia() MediaObject {
+	return v.video
+}
+
+func (v *YoutubeVideoInList) MediaList() MediaObject {
+	return v.list
+}
+
+// The uploads playlist of channel UCxxx is UUxxx.
+func uploadsPlaylistId(channelId string) (id string, ok bool) {
+	rest, found := strings.CutPrefix(channelId, "UC")
+	if !found || !checkPlaylistId(rest) {
+		return "", false
+	}
+
+	return "UU" + rest, true
+}
+
 type YoutubeResolver interface {
 	SearchMedia(src IdMediaSource[string], ctx context.Context, query string) (CanonicalizedMediaObject, error)
 	ResolveMedia(src IdMediaSource[string], ctx context.Context, id string) (ResolvedMediaObjectSingle, error)
 	ResolveMediaList(src IdMediaSource[string], ctx context.Context, id string) (ResolvedMediaObject, error)
+	ResolveChannelId(ctx context.Context, channelURL *url.URL) (string, error)
+	Search(src IdMediaSource[string], ctx context.Context, query string, limit int) ([]SearchResult, error)
 }
 
 type YoutubeAPI struct {
-	apiKey string
+	apiKey   string
+	endpoint string
+	quota    *YoutubeQuota
+	fallback *YtdlResolver
 }
 
+// Maximum number of results per page and IDs per request of the API.
+const youtubeAPIPageSize = 50
+
 func (yt *YoutubeAPI) newClient(ctx context.Context) (*youtube.Service, error) {
-	return youtube.NewService(ctx, option.WithAPIKey(yt.apiKey))
+	opts := []option.ClientOption{option.WithAPIKey(yt.apiKey)}
+	if yt.endpoint != "" {
+		opts = append(opts, option.WithEndpoint(yt.endpoint))
+	}
+
+	return youtube.NewService(ctx, opts...)
+}
+
+func (yt *YoutubeAPI) reserve(cost int) error {
+	if !yt.quota.Reserve(cost) {
+		return ErrYoutubeQuotaExhausted
+	}
+
+	return nil
+}
+
+// Whether a failed API call should be retried with yt-dlp.
+func (yt *YoutubeAPI) shouldFallback(err error) bool {
+	return errors.Is(err, ErrYoutubeQuotaExhausted) || yt.quota.checkError(err)
 }
 
 func (yt *YoutubeAPI) SearchMedia(src IdMediaSource[string], ctx context.Context, query string) (CanonicalizedMediaObject, error) {
+	m, err := yt.searchMedia(src, ctx, query)
+	if err != nil && yt.shouldFallback(err) {
+		return yt.fallback.SearchMedia(src, ctx, query)
+	}
+
+	return m, err
+}
+
+func (yt *YoutubeAPI) searchMedia(src IdMediaSource[string], ctx context.Context, query string) (CanonicalizedMediaObject, error) {
 	client, err := yt.newClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := client.Search.List([]string{"part", "snippet", "contentDetails"}).Q(query).MaxResults(1).Type("video").Do()
+	if err = yt.reserve(YoutubeQuotaCostSearch); err != nil {
+		return nil, err
+	}
+
+	response, err := client.Search.List([]string{"snippet"}).Q(query).MaxResults(1).Type("video").Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
@@ -113,46 +215,250 @@ func (yt *YoutubeAPI) SearchMedia(src IdMediaSource[string], ctx context.Context
 		return nil, ErrMediaNotFound
 	}
 
-	video := response.Items[1]
+	video := response.Items[0]
 	id := video.Id.VideoId
 	return NewIdMediaObject[string](src, id, nil), nil
 }
 
+func (yt *YoutubeAPI) Search(src IdMediaSource[string], ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	results, err := yt.search(src, ctx, query, limit)
+	if err != nil && yt.shouldFallback(err) {
+		return yt.fallback.Search(src, ctx, query, limit)
+	}
+
+	return results, err
+}
+
+func (yt *YoutubeAPI) search(src IdMediaSource[string], ctx context.Context, query string, limit int) (results []SearchResult, err error) {
+	client, err := yt.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = yt.reserve(YoutubeQuotaCostSearch); err != nil {
+		return nil, err
+	}
+
+	response, err := client.Search.List([]string{"snippet"}).Q(query).MaxResults(int64(limit)).Type("video").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, item := range response.Items {
+		ids = append(ids, item.Id.VideoId)
+	}
+
+	// search results do not have durations
+	medias, err := yt.resolveVideos(src, ctx, client, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, media := range medias {
+		results = append(results, SearchResult{
+			URL:       media.URL(),
+			Title:     media.Title(),
+			Artist:    media.Artist(),
+			Duration:  media.Duration(),
+			Thumbnail: media.Thumbnail(),
+		})
+	}
+
+	return results, nil
+}
+
 func (yt *YoutubeAPI) ResolveMedia(src IdMediaSource[string], ctx context.Context, id string) (ResolvedMediaObjectSingle, error) {
 	client, err := yt.newClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := client.Videos.List([]string{"snippet", "contentDetails"}).Id(id).MaxResults(1).Do()
+	medias, err := yt.resolveVideos(src, ctx, client, []string{id})
 	if err != nil {
+		if yt.shouldFallback(err) {
+			return yt.fallback.ResolveMedia(src, ctx, id)
+		}
+
 		return nil, err
 	}
 
-	if len(response.Items) < 1 {
+	if len(medias) < 1 {
 		return nil, ErrMediaNotFound
 	}
 
-	video := response.Items[0]
-	videoLength, err := duration.ParseISO8601(video.ContentDetails.Duration)
-	if err != nil && video.ContentDetails.Duration != "" {
+	return &medias[0], nil
+}
+
+func (yt *YoutubeAPI) ResolveMediaList(src IdMediaSource[string], ctx context.Context, id string) (ResolvedMediaObject, error) {
+	list, err := yt.resolveMediaList(src, ctx, id)
+	if err != nil && yt.shouldFallback(err) {
+		return yt.fallback.ResolveMediaList(src, ctx, id)
+	}
+
+	return list, err
+}
+
+func (yt *YoutubeAPI) resolveMediaList(src IdMediaSource[string], ctx context.Context, id string) (ResolvedMediaObject, error) {
+	client, err := yt.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = yt.reserve(YoutubeQuotaCostList); err != nil {
 		return nil, err
 	}
 
-	return NewIdMediaObject(src, id, &IdMediaObjectResolveInfo{
-		id:          id,
-		title:       firstNonEmpty(video.Snippet.Title, UnknownTitle),
-		artist:      firstNonEmpty(video.Snippet.ChannelTitle, UnknownArtist),
-		length:      isoDurationToGoDuration(videoLength),
-		aspectRatio: "16/9",
+	playlists, err := client.Playlists.List([]string{"snippet"}).Id(id).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(playlists.Items) < 1 {
+		// mixes and other auto-generated lists are not available through the
+		// API, yt-dlp might still be able to resolve them
+		slog.Debug("Playlist not found with YouTube API, falling back to yt-dlp", "id", id)
+		return yt.fallback.ResolveMediaList(src, ctx, id)
+	}
+
+	var videoIds []string
+	pageToken := ""
+	for {
+		if err = yt.reserve(YoutubeQuotaCostList); err != nil {
+			return nil, err
+		}
+
+		call := client.PlaylistItems.List([]string{"contentDetails"}).
+			PlaylistId(id).
+			MaxResults(youtubeAPIPageSize).
+			Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		response, err := call.Do()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range response.Items {
+			videoIds = append(videoIds, item.ContentDetails.VideoId)
+		}
+
+		pageToken = response.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	medias, err := yt.resolveVideos(src, ctx, client, videoIds)
+	if err != nil {
+		return nil, err
+	}
+
+	snippet := playlists.Items[0].Snippet
+	return NewIdMediaListObject(src, id, &IdMediaListObjectResolveInfo[string]{
+		title:  firstNonEmpty(snippet.Title, UnknownTitle),
+		artist: firstNonEmpty(snippet.ChannelTitle, UnknownArtist),
+		medias: medias,
 	}), nil
 }
 
-func (yt *YoutubeAPI) ResolveMediaList(src IdMediaSource[string], ctx context.Context, id string) (ResolvedMediaObject, error) {
-	// unsupported due to quota and shit
-	return NewYoutubeDL().ResolveMediaList(ctx, id)
+// Resolve videos in batches of youtubeAPIPageSize, preserving the order of
+// ids. Private and deleted videos are skipped.
+func (yt *YoutubeAPI) resolveVideos(src IdMediaSource[string], ctx context.Context, client *youtube.Service, ids []string) (medias []IdMediaObject[string], err error) {
+	for batch := range slices.Chunk(ids, youtubeAPIPageSize) {
+		if err = yt.reserve(YoutubeQuotaCostList); err != nil {
+			return nil, err
+		}
+
+		response, err := client.Videos.List([]string{"snippet", "contentDetails"}).
+			Id(batch...).
+			MaxResults(youtubeAPIPageSize).
+			Context(ctx).
+			Do()
+		if err != nil {
+			return nil, err
+		}
+
+		videos := make(map[string]*youtube.Video)
+		for _, video := range response.Items {
+			videos[video.Id] = video
+		}
+
+		for _, id := range batch {
+			video, ok := videos[id]
+			if !ok {
+				slog.Debug("Video not available with YouTube API, skipping", "id", id)
+				continue
+			}
+
+			videoLength, err := duration.ParseISO8601(video.ContentDetails.Duration)
+			if err != nil && video.ContentDetails.Duration != "" {
+				return nil, err
+			}
+
+			medias = append(medias, *NewIdMediaObject(src, id, &IdMediaObjectResolveInfo{
+				id:          id,
+				title:       firstNonEmpty(video.Snippet.Title, UnknownTitle),
+				artist:      firstNonEmpty(video.Snippet.ChannelTitle, UnknownArtist),
+				length:      isoDurationToGoDuration(videoLength),
+				aspectRatio: "16/9",
+				thumbnail:   snippetThumbnail(video.Snippet.Thumbnails),
+			}))
+		}
+	}
+
+	return medias, nil
+}
+
+func (yt *YoutubeAPI) ResolveChannelId(ctx context.Context, channelURL *url.URL) (string, error) {
+	id, err := yt.resolveChannelId(ctx, channelURL)
+	if err != nil && yt.shouldFallback(err) {
+		return yt.fallback.ResolveChannelId(ctx, channelURL)
+	}
+
+	return id, err
+}
+
+func (yt *YoutubeAPI) resolveChannelId(ctx context.Context, channelURL *url.URL) (string, error) {
+	path := strings.TrimPrefix(channelURL.Path, "/")
+	call := func(c *youtube.ChannelsListCall) (string, error) {
+		if err := yt.reserve(YoutubeQuotaCostList); err != nil {
+			return "", err
+		}
+
+		response, err := c.MaxResults(1).Context(ctx).Do()
+		if err != nil {
+			return "", err
+		}
+
+		if len(response.Items) < 1 {
+			return "", ErrMediaNotFound
+		}
+
+		return response.Items[0].Id, nil
+	}
+
+	client, err := yt.newClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if handle, found := strings.CutPrefix(path, "@"); found {
+		return call(client.Channels.List([]string{"id"}).ForHandle(handle))
+	}
+
+	if username, found := strings.CutPrefix(path, "user/"); found {
+		return call(client.Channels.List([]string{"id"}).ForUsername(username))
+	}
+
+	// custom URLs (/c/...) can not be looked up with the API
+	return yt.fallback.ResolveChannelId(ctx, channelURL)
 }
 
+var youtubeHosts = []string{"youtu.be", "yt.be", "youtube.com", "www.youtube.com", "m.youtube.com", "music.youtube.com", "www.youtube-nocookie.com"}
+
 func processYoutubeURL(src *YoutubeSource, u *url.URL) (MediaObject, error) {
 	if u.Scheme != "https" && u.Scheme != "http" {
 		return nil, ErrUnsupportedURL
@@ -164,17 +470,20 @@ func processYoutubeURL(src *YoutubeSource, u *url.URL) (MediaObject, error) {
 	if strings.HasPrefix(path, "/") {
 		path = path[1:]
 	}
+	path = strings.TrimSuffix(path, "/")
 
-	if u.Hostname() != "youtu.be" && u.Hostname() != "yt.be" && u.Hostname() != "youtube.com" && u.Hostname() != "www.youtube.com" {
+	if !slices.Contains(youtubeHosts, u.Hostname()) {
 		return nil, ErrUnsupportedURL
 	}
 
-	if id, found := strings.CutPrefix(path, "shorts/"); found {
-		if !checkVideoId(id) {
-      return nil, ErrInvalidYTURL
-		}
+	for _, prefix := range []string{"shorts/", "live/", "embed/"} {
+		if id, found := strings.CutPrefix(path, prefix); found {
+			if !checkVideoId(id) {
+				return nil, ErrInvalidYTURL
+			}
 
-		return NewIdMediaObject(src, id, nil), nil
+			return NewIdMediaObject(src, id, nil), nil
+		}
 	}
 
 	if path == "watch" {
@@ -183,7 +492,12 @@ func processYoutubeURL(src *YoutubeSource, u *url.URL) (MediaObject, error) {
 			return nil, ErrInvalidYTURL
 		}
 
-		return NewIdMediaObject(src, id, nil), nil
+		video := NewIdMediaObject(src, id, nil)
+		if listId := query.Get("list"); checkPlaylistId(listId) {
+			return &YoutubeVideoInList{video: video, list: NewIdMediaListObject(src, listId, nil)}, nil
+		}
+
+		return video, nil
 	}
 
 	if path == "playlist" {
@@ -195,6 +509,32 @@ func processYoutubeURL(src *YoutubeSource, u *url.URL) (MediaObject, error) {
 		return NewIdMediaListObject(src, id, nil), nil
 	}
 
+	// channel pages and their tabs (/videos, /streams, ...) all map to the
+	// uploads playlist of the channel
+	parts := strings.Split(path, "/")
+	if parts[0] == "channel" && len(parts) >= 2 {
+		id, ok := uploadsPlaylistId(parts[1])
+		if !ok {
+			return nil, ErrInvalidYTURL
+		}
+
+		return NewIdMediaListObject(src, id, nil), nil
+	}
+
+	if strings.HasPrefix(parts[0], "@") || ((parts[0] == "c" || parts[0] == "user") && len(parts) >= 2) {
+		channelPath := parts[0]
+		if !strings.HasPrefix(channelPath, "@") {
+			channelPath += "/" + parts[1]
+		}
+
+		channelURL, err := url.Parse("https://www.youtube.com/" + channelPath)
+		if err != nil {
+			return nil, ErrInvalidYTURL
+		}
+
+		return newYoutubeChannel(src, channelURL), nil
+	}
+
 	if checkVideoId(path) {
 		return NewIdMediaObject(src, path, nil), nil
 	}
@@ -214,8 +554,15 @@ func processYoutubeURL(src *YoutubeSource, u *url.URL) (MediaObject, error) {
 	return nil, ErrInvalidYTURL
 }
 
-func NewYoutubeAPI(apiKey string) *YoutubeSource {
-	return &YoutubeSource{resolver: &YoutubeAPI{apiKey: apiKey}}
+// endpoint overrides the API root URL (e.g. http://localhost:8080/ to use a
+// local fake of the API), leave empty to use the default one.
+func NewYoutubeAPI(apiKey, endpoint string, quotaBudget int) *YoutubeSource {
+	return &YoutubeSource{resolver: &YoutubeAPI{
+		apiKey:   apiKey,
+		endpoint: endpoint,
+		quota:    NewYoutubeQuota(quotaBudget),
+		fallback: NewYoutubeYtdlResolver(),
+	}}
 }
 
 func NewYoutubeDL() *YoutubeSource {
@@ -238,6 +585,10 @@ func (yt *YoutubeSource) MediaListURL(id string) *url.URL {
 	return NewYoutubeYtdlResolver().MediaListURL(id)
 }
 
+func (yt *YoutubeSource) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	return yt.resolver.Search(yt, ctx, query, limit)
+}
+
 func (yt *YoutubeSource) ResolveMedia(ctx context.Context, id string) (ResolvedMediaObjectSingle, error) {
 	return yt.resolver.ResolveMedia(yt, ctx, id)
 }
@@ -245,3 +596,17 @@ func (yt *YoutubeSource) ResolveMedia(ctx context.Context, id string) (ResolvedM
 func (yt *YoutubeSource) ResolveMediaList(ctx context.Context, id string) (ResolvedMediaObject, error) {
 	return yt.resolver.ResolveMediaList(yt, ctx, id)
 }
+
+func snippetThumbnail(t *youtube.ThumbnailDetails) string {
+	if t == nil {
+		return ""
+	}
+
+	for _, thumbnail := range []*youtube.Thumbnail{t.Maxres, t.High, t.Medium, t.Default} {
+		if thumbnail != nil {
+			return thumbnail.Url
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,94 @@
+package media
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Quota costs of the YouTube Data API v3 endpoints we use, see
+// https://developers.google.com/youtube/v3/determine_quota_cost
+const (
+	YoutubeQuotaCostList   = 1
+	YoutubeQuotaCostSearch = 100
+
+	DefaultYoutubeQuotaBudget = 10000
+)
+
+var ErrYoutubeQuotaExhausted = errors.New("YouTube API quota exhausted")
+
+// The daily quota resets at midnight Pacific Time. DST is ignored, so the
+// reset might happen an hour earlier than expected, which is harmless.
+var youtubeQuotaTimezone = time.FixedZone("PT", -8*60*60)
+
+type YoutubeQuota struct {
+	budget  int
+	used    int
+	resetAt time.Time
+	mutex   sync.Mutex
+}
+
+func NewYoutubeQuota(budget int) *YoutubeQuota {
+	return &YoutubeQuota{budget: budget}
+}
+
+func nextQuotaReset(now time.Time) time.Time {
+	now = now.In(youtubeQuotaTimezone)
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, youtubeQuotaTimezone)
+}
+
+func (q *YoutubeQuota) resetIfNeeded(now time.Time) {
+	if now.Before(q.resetAt) {
+		return
+	}
+
+	q.used = 0
+	q.resetAt = nextQuotaReset(now)
+}
+
+// Reserve cost units of quota, returning false if that would exceed the
+// budget.
+func (q *YoutubeQuota) Reserve(cost int) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.resetIfNeeded(time.Now())
+	if q.used+cost > q.budget {
+		return false
+	}
+
+	q.used += cost
+	return true
+}
+
+// Mark the quota as exhausted until the next reset, used when the API
+// reports that we ran out of quota before our own budget did.
+func (q *YoutubeQuota) Exhaust() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.resetIfNeeded(time.Now())
+	q.used = q.budget
+}
+
+// Check whether err is a quota error returned by the API, exhausting the
+// quota if it is.
+func (q *YoutubeQuota) checkError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	for _, item := range apiErr.Errors {
+		if item.Reason == "quotaExceeded" || item.Reason == "dailyLimitExceeded" {
+			slog.Warn("YouTube API quota exceeded, falling back to yt-dlp", "err", err)
+			q.Exhaust()
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,275 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Local fake of the parts of the YouTube Data API used by YoutubeAPI, serving
+// a single playlist of numVideos videos.
+type fakeYoutubeAPI struct {
+	numVideos int
+	// video IDs that are not returned by videos.list, like deleted videos
+	missing map[string]bool
+	// reason of the error returned by every call, if set
+	errorReason string
+
+	mutex        sync.Mutex
+	calls        map[string]int
+	videoBatches []int
+}
+
+func newFakeYoutubeAPI(t *testing.T, numVideos int) (*fakeYoutubeAPI, *httptest.Server) {
+	fake := &fakeYoutubeAPI{numVideos: numVideos, missing: map[string]bool{}, calls: map[string]int{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func fakeVideoId(i int) string {
+	return fmt.Sprintf("video%06d", i)
+}
+
+func (f *fakeYoutubeAPI) numCalls(endpoint string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.calls[endpoint]
+}
+
+func (f *fakeYoutubeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.calls[r.URL.Path]++
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "application/json")
+
+	if f.errorReason != "" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{
+			"code":    http.StatusForbidden,
+			"message": f.errorReason,
+			"errors":  []map[string]any{{"reason": f.errorReason, "domain": "youtube.quota"}},
+		}})
+		return
+	}
+
+	var response map[string]any
+	switch r.URL.Path {
+	case "/youtube/v3/playlists":
+		response = map[string]any{"items": []map[string]any{{
+			"id":      query.Get("id"),
+			"snippet": map[string]any{"title": "Fake list", "channelTitle": "Fake channel"},
+		}}}
+	case "/youtube/v3/playlistItems":
+		start, _ := strconv.Atoi(query.Get("pageToken"))
+		pageSize, _ := strconv.Atoi(query.Get("maxResults"))
+		end := min(start+pageSize, f.numVideos)
+
+		var items []map[string]any
+		for i := start; i < end; i++ {
+			items = append(items, map[string]any{"contentDetails": map[string]any{"videoId": fakeVideoId(i)}})
+		}
+
+		response = map[string]any{"items": items}
+		if end < f.numVideos {
+			response["nextPageToken"] = strconv.Itoa(end)
+		}
+	case "/youtube/v3/videos":
+		ids := query["id"]
+		f.videoBatches = append(f.videoBatches, len(ids))
+
+		var items []map[string]any
+		// answered out of order, like the real API may do
+		for i := len(ids) - 1; i >= 0; i-- {
+			if f.missing[ids[i]] {
+				continue
+			}
+
+			items = append(items, map[string]any{
+				"id":             ids[i],
+				"snippet":        map[string]any{"title": "Title of " + ids[i], "channelTitle": "Fake channel"},
+				"contentDetails": map[string]any{"duration": "PT1M5S"},
+			})
+		}
+
+		response = map[string]any{"items": items}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func newTestYoutubeAPI(server *httptest.Server, budget int) *YoutubeSource {
+	return NewYoutubeAPI("key", server.URL+"/", budget)
+}
+
+func TestYoutubeAPIResolveMediaList(t *testing.T) {
+	fake, server := newFakeYoutubeAPI(t, 120)
+	fake.missing[fakeVideoId(7)] = true
+	src := newTestYoutubeAPI(server, DefaultYoutubeQuotaBudget)
+
+	list, err := src.ResolveMediaList(context.Background(), "PLfake")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list.Title() != "Fake list" || list.Artist() != "Fake channel" {
+		t.Errorf("unexpected list %q by %q", list.Title(), list.Artist())
+	}
+
+	entries := list.ChildEntries()
+	if len(entries) != 119 {
+		t.Fatalf("expected 119 entries, got %d", len(entries))
+	}
+
+	for i, entry := range entries {
+		videoIndex := i
+		if i >= 7 {
+			videoIndex++
+		}
+
+		id := fakeVideoId(videoIndex)
+		if entry.URL().String() != "https://youtu.be/"+id || entry.Title() != "Title of "+id || entry.Duration() != 65*time.Second {
+			t.Fatalf("unexpected entry %d: %s %q %v", i, entry.URL(), entry.Title(), entry.Duration())
+		}
+	}
+
+	if calls := fake.numCalls("/youtube/v3/playlistItems"); calls != 3 {
+		t.Errorf("expected 3 pages of playlist items, got %d", calls)
+	}
+
+	if fmt.Sprint(fake.videoBatches) != "[50 50 20]" {
+		t.Errorf("expected videos.list batches of at most 50 IDs, got %v", fake.videoBatches)
+	}
+}
+
+func TestYoutubeAPIResolveMedia(t *testing.T) {
+	_, server := newFakeYoutubeAPI(t, 0)
+	src := newTestYoutubeAPI(server, DefaultYoutubeQuotaBudget)
+
+	m, err := src.ResolveMedia(context.Background(), "dQw4w9WgXcQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Title() != "Title of dQw4w9WgXcQ" || m.Duration() != 65*time.Second {
+		t.Errorf("unexpected media %q, %v", m.Title(), m.Duration())
+	}
+}
+
+func TestYoutubeAPIQuotaExceededFallback(t *testing.T) {
+	log := useYtdlStub(t, "playlist.json")
+	fake, server := newFakeYoutubeAPI(t, 10)
+	fake.errorReason = "quotaExceeded"
+	src := newTestYoutubeAPI(server, DefaultYoutubeQuotaBudget)
+
+	list, err := src.ResolveMediaList(context.Background(), "PLstub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list.Title() != "Stub list" || len(list.ChildEntries()) != 2 {
+		t.Errorf("expected the list from yt-dlp, got %q with %d entries", list.Title(), len(list.ChildEntries()))
+	}
+
+	// the quota is exhausted until the next reset, so the API is not called
+	// again
+	if _, err = src.ResolveMediaList(context.Background(), "PLstub"); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := fake.numCalls("/youtube/v3/playlists"); calls != 1 {
+		t.Errorf("expected a single API call, got %d", calls)
+	}
+
+	if calls := readStubLog(t, log); len(calls) != 2 {
+		t.Errorf("expected 2 yt-dlp calls, got %q", calls)
+	}
+}
+
+func TestYoutubeAPIBudgetFallback(t *testing.T) {
+	useYtdlStub(t, "playlist.json")
+	fake, server := newFakeYoutubeAPI(t, 120)
+	// enough for the playlist and the first page of items only
+	src := newTestYoutubeAPI(server, 2)
+
+	list, err := src.ResolveMediaList(context.Background(), "PLstub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list.Title() != "Stub list" {
+		t.Errorf("expected the list from yt-dlp, got %q", list.Title())
+	}
+
+	if calls := fake.numCalls("/youtube/v3/playlistItems"); calls != 1 {
+		t.Errorf("expected the API to stop at the budget, got %d playlist item calls", calls)
+	}
+}
+
+func TestYoutubeProcessURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+		err      error
+	}{
+		{"https://youtu.be/dQw4w9WgXcQ", "media dQw4w9WgXcQ", nil},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "media dQw4w9WgXcQ", nil},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", "media dQw4w9WgXcQ", nil},
+		{"https://www.youtube.com/live/dQw4w9WgXcQ", "media dQw4w9WgXcQ", nil},
+		{"https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", "media dQw4w9WgXcQ", nil},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ", "media dQw4w9WgXcQ", nil},
+		{"https://music.youtube.com/playlist?list=OLAK5uy_abc", "list OLAK5uy_abc", nil},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL123", "media dQw4w9WgXcQ in list PL123", nil},
+		{"https://www.youtube.com/playlist?list=PL123", "list PL123", nil},
+		{"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", "list UUuAXFkgsw1L7xaCfnd5JJOw", nil},
+		{"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw/videos", "list UUuAXFkgsw1L7xaCfnd5JJOw", nil},
+		{"https://www.youtube.com/@artist/streams", "channel https://www.youtube.com/@artist", nil},
+		{"https://www.youtube.com/c/artist", "channel https://www.youtube.com/c/artist", nil},
+		{"https://www.youtube.com/user/artist/videos", "channel https://www.youtube.com/user/artist", nil},
+		{"https://www.youtube.com/results?search_query=never+gonna", "", ErrInvalidYTURL},
+		{"https://www.youtube.com/?search_query=never+gonna", "query never gonna", nil},
+		{"https://www.youtube.com/channel/HCuAXFkgsw1L7xaCfnd5JJOw", "", ErrInvalidYTURL},
+		{"https://www.youtube.com/live/short", "", ErrInvalidYTURL},
+		{"https://www.youtube.com/watch?v=short", "", ErrInvalidYTURL},
+		{"https://www.youtube.com/playlist", "", ErrInvalidYTURL},
+		{"https://example.com/watch?v=dQw4w9WgXcQ", "", ErrUnsupportedURL},
+		{"ftp://youtu.be/dQw4w9WgXcQ", "", ErrUnsupportedURL},
+	}
+
+	yt := NewYoutubeDL()
+	for _, test := range tests {
+		checkProcessURL(t, yt, test.url, test.expected, test.err)
+	}
+}
+
+func TestUploadsPlaylistId(t *testing.T) {
+	tests := []struct {
+		channelId string
+		expected  string
+		ok        bool
+	}{
+		{"UCuAXFkgsw1L7xaCfnd5JJOw", "UUuAXFkgsw1L7xaCfnd5JJOw", true},
+		{"UC", "", false},
+		{"UUuAXFkgsw1L7xaCfnd5JJOw", "", false},
+		{"UCuAXF/kgsw", "", false},
+	}
+
+	for _, test := range tests {
+		id, ok := uploadsPlaylistId(test.channelId)
+		if id != test.expected || ok != test.ok {
+			t.Errorf("uploadsPlaylistId(%q) = %q, %v, expected %q, %v", test.channelId, id, ok, test.expected, test.ok)
+		}
+	}
+}
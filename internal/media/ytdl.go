@@ -3,17 +3,46 @@ package media
 import (
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/url"
+	"os/exec"
 	"time"
 
 	"github.com/wader/goutubedl"
 )
 
+var ytdlExtraArgs []string
+
+// How long to wait for the output of yt-dlp after killing it at its deadline,
+// processes it spawned might still hold it open.
+const ytdlWaitDelay = time.Second
+
+// Set the yt-dlp binary (looked up in PATH if empty) and extra arguments
+// passed to every invocation of it.
+func ConfigureYtdl(path string, extraArgs []string) {
+	goutubedl.Path = path
+	ytdlExtraArgs = extraArgs
+}
+
+func ytdlNew(ctx context.Context, rawURL string, options goutubedl.Options) (goutubedl.Result, error) {
+	// goutubedl has no options for arbitrary arguments or the wait delay, but
+	// this hook is called with the command right before it is run
+	options.StderrFn = func(cmd *exec.Cmd) io.Writer {
+		cmd.Args = append(cmd.Args, ytdlExtraArgs...)
+		cmd.WaitDelay = ytdlWaitDelay
+		return io.Discard
+	}
+
+	return goutubedl.New(ctx, rawURL, options)
+}
+
 type YtdlResolver struct {
 	mediaUrlPattern     string
 	mediaListUrlPattern string
 	searchPrefix        string
 	idExtractor         func(goutubedl.Info) string
+	permalinkExtractor  func(goutubedl.Info) string
 }
 
 func NewYtdlResolver(mediaUrlPattern, mediaListUrlPattern, searchPrefix string) *YtdlResolver {
@@ -42,29 +71,151 @@ func (yt *YtdlResolver) MediaListURL(id string) *url.URL {
 	return u
 }
 
+func (yt *YtdlResolver) search(ctx context.Context, query string, limit int) ([]goutubedl.Info, error) {
+	if yt.searchPrefix == "" {
+		return nil, ErrUnsupportedOperation
+	}
+
+	result, err := ytdlNew(ctx, fmt.Sprintf("%s%d:%s", yt.searchPrefix, limit, query), goutubedl.Options{
+		Type:         goutubedl.TypePlaylist,
+		FlatPlaylist: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Info.Entries, nil
+}
+
 func (yt *YtdlResolver) SearchMedia(src IdMediaSource[string], ctx context.Context, query string) (CanonicalizedMediaObject, error) {
-	panic("unsupported")
+	entries, err := yt.search(ctx, query, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if id := yt.entryId(entry); id != "" {
+			return NewIdMediaObject(src, id, nil), nil
+		}
+	}
+
+	return nil, ErrMediaNotFound
+}
+
+func (yt *YtdlResolver) Search(src IdMediaSource[string], ctx context.Context, query string, limit int) (results []SearchResult, err error) {
+	entries, err := yt.search(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		id := yt.entryId(entry)
+		if id == "" {
+			continue
+		}
+
+		results = append(results, SearchResult{
+			URL:       src.MediaURL(id),
+			Title:     firstNonEmpty(entry.Title, UnknownTitle),
+			Artist:    firstNonEmpty(entry.Channel, entry.Uploader, UnknownArtist),
+			Duration:  time.Duration(entry.Duration) * time.Second,
+			Thumbnail: entryThumbnail(entry),
+		})
+	}
+
+	return results, nil
+}
+
+func (yt *YtdlResolver) entryId(entry goutubedl.Info) string {
+	if yt.idExtractor != nil {
+		return yt.idExtractor(entry)
+	}
+
+	return entry.ID
+}
+
+// flat entries usually only have the thumbnails list, which is sorted from
+// worst to best
+func entryThumbnail(entry goutubedl.Info) string {
+	if entry.Thumbnail != "" {
+		return entry.Thumbnail
+	}
+
+	if len(entry.Thumbnails) > 0 {
+		return entry.Thumbnails[len(entry.Thumbnails)-1].URL
+	}
+
+	return ""
+}
+
+func (yt *YtdlResolver) ResolveChannelId(ctx context.Context, channelURL *url.URL) (string, error) {
+	result, err := ytdlNew(ctx, channelURL.String(), goutubedl.Options{
+		Type:         goutubedl.TypePlaylist,
+		FlatPlaylist: true,
+		PlaylistEnd:  1,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if result.Info.ChannelID == "" {
+		return "", ErrMediaNotFound
+	}
+
+	return result.Info.ChannelID, nil
 }
 
 func (yt *YtdlResolver) ResolveMedia(src IdMediaSource[string], ctx context.Context, id string) (ResolvedMediaObjectSingle, error) {
-	result, err := goutubedl.New(ctx, yt.MediaURL(id).String(), goutubedl.Options{
+	result, err := ytdlNew(ctx, yt.MediaURL(id).String(), goutubedl.Options{
 		Type: goutubedl.TypeSingle,
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	return yt.newResolvedMedia(src, id, result.Info), nil
+}
+
+// Resolve a media from a URL that is not its canonical URL, e.g. a SoundCloud
+// permalink, using idExtractor to find the canonical ID.
+func (yt *YtdlResolver) ResolveMediaFromURL(src IdMediaSource[string], ctx context.Context, u *url.URL) (ResolvedMediaObjectSingle, error) {
+	result, err := ytdlNew(ctx, u.String(), goutubedl.Options{
+		Type: goutubedl.TypeSingle,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id := yt.entryId(result.Info)
+	if id == "" {
+		return nil, ErrMediaNotFound
+	}
+
+	return yt.newResolvedMedia(src, id, result.Info), nil
+}
+
+func (yt *YtdlResolver) newResolvedMedia(src IdMediaSource[string], id string, info goutubedl.Info) *IdMediaObject[string] {
 	return NewIdMediaObject(src, id, &IdMediaObjectResolveInfo{
 		id:          id,
-		title:       firstNonEmpty(result.Info.Title, UnknownTitle),
-		artist:      firstNonEmpty(result.Info.Channel, result.Info.Uploader, UnknownArtist),
-		length:      time.Duration(result.Info.Duration) * time.Second,
-		aspectRatio: fmt.Sprintf("%d/%d", int(result.Info.Width), int(result.Info.Height)),
-	}), nil
+		title:       firstNonEmpty(info.Title, UnknownTitle),
+		artist:      firstNonEmpty(info.Channel, info.Uploader, UnknownArtist),
+		length:      time.Duration(info.Duration) * time.Second,
+		aspectRatio: fmt.Sprintf("%d/%d", int(info.Width), int(info.Height)),
+		permalink:   yt.permalink(info),
+		thumbnail:   entryThumbnail(info),
+	})
+}
+
+func (yt *YtdlResolver) permalink(info goutubedl.Info) string {
+	if yt.permalinkExtractor == nil {
+		return ""
+	}
+
+	return yt.permalinkExtractor(info)
 }
 
 func (yt *YtdlResolver) ResolveMediaList(src IdMediaSource[string], ctx context.Context, id string) (ResolvedMediaObject, error) {
-	result, err := goutubedl.New(ctx, yt.MediaListURL(id).String(), goutubedl.Options{
+	result, err := ytdlNew(ctx, yt.MediaListURL(id).String(), goutubedl.Options{
 		Type:         goutubedl.TypePlaylist,
 		FlatPlaylist: true,
 	})
@@ -74,16 +225,19 @@ func (yt *YtdlResolver) ResolveMediaList(src IdMediaSource[string], ctx context.
 
 	var medias []IdMediaObject[string]
 	for _, video := range result.Info.Entries {
-		mediaId := video.ID
-		if yt.idExtractor != nil {
-			id = yt.idExtractor(video)
+		mediaId := yt.entryId(video)
+		if mediaId == "" {
+			slog.Debug("Skipping media list entry without ID", "list", id, "entry", video.Title)
+			continue
 		}
 		medias = append(medias, *NewIdMediaObject(src, mediaId, &IdMediaObjectResolveInfo{
 			id:          mediaId,
 			title:       firstNonEmpty(video.Title, UnknownTitle),
-			artist:      firstNonEmpty(video.Channel, video.Uploader),
+			artist:      firstNonEmpty(video.Channel, video.Uploader, UnknownArtist),
 			length:      time.Duration(video.Duration) * time.Second,
 			aspectRatio: "16/9",
+			permalink:   yt.permalink(video),
+			thumbnail:   entryThumbnail(video),
 		}))
 	}
 
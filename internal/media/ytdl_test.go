@@ -0,0 +1,134 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Point the yt-dlp resolvers at testdata/yt-dlp, answering with the info JSON
+// in testdata/response. Returns the path of the invocation log.
+func useYtdlStub(t *testing.T, response string, extraArgs ...string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the yt-dlp stub is a shell script")
+	}
+
+	stub, err := filepath.Abs("testdata/yt-dlp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := filepath.Join(t.TempDir(), "yt-dlp.log")
+	if response != "" {
+		response, err = filepath.Abs(filepath.Join("testdata", response))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("YTDLP_STUB_RESPONSE", response)
+	t.Setenv("YTDLP_STUB_LOG", log)
+	ConfigureYtdl(stub, extraArgs)
+	t.Cleanup(func() { ConfigureYtdl("", nil) })
+	return log
+}
+
+func readStubLog(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestYtdlResolveMedia(t *testing.T) {
+	log := useYtdlStub(t, "video.json", "--cookies", "cookies.txt")
+	src := NewYoutubeDL()
+
+	m, err := NewYoutubeYtdlResolver().ResolveMedia(src, context.Background(), "dQw4w9WgXcQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Title() != "Stub video" || m.Artist() != "Stub channel" || m.Duration() != 212*time.Second || m.AspectRatio() != "1280/720" {
+		t.Errorf("unexpected media %q by %q, %v, %s", m.Title(), m.Artist(), m.Duration(), m.AspectRatio())
+	}
+
+	calls := readStubLog(t, log)
+	if len(calls) != 1 || !strings.HasPrefix(calls[0], "https://youtu.be/dQw4w9WgXcQ ") || !strings.HasSuffix(calls[0], " --cookies cookies.txt") {
+		t.Errorf("unexpected yt-dlp invocations %q", calls)
+	}
+}
+
+func TestYtdlResolveMediaList(t *testing.T) {
+	useYtdlStub(t, "playlist.json")
+	src := NewYoutubeDL()
+
+	list, err := NewYoutubeYtdlResolver().ResolveMediaList(src, context.Background(), "PLstub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := list.ChildEntries()
+	if list.Title() != "Stub list" || len(entries) != 2 || entries[1].URL().String() != "https://youtu.be/bbbbbbbbbbb" {
+		t.Errorf("unexpected list %q with %d entries", list.Title(), len(entries))
+	}
+}
+
+func TestYtdlError(t *testing.T) {
+	useYtdlStub(t, "")
+
+	_, err := NewYoutubeYtdlResolver().ResolveMedia(NewYoutubeDL(), context.Background(), "dQw4w9WgXcQ")
+	if err == nil || !strings.Contains(err.Error(), "no stub response") {
+		t.Errorf("expected the yt-dlp error, got %v", err)
+	}
+}
+
+func TestResolverPoolTimeout(t *testing.T) {
+	useYtdlStub(t, "video.json")
+	t.Setenv("YTDLP_STUB_SLEEP", "5")
+	pool := NewResolverPool(1, 100*time.Millisecond)
+
+	start := time.Now()
+	err := pool.Do(context.Background(), func(ctx context.Context) error {
+		_, err := NewYoutubeYtdlResolver().ResolveMedia(NewYoutubeDL(), ctx, "dQw4w9WgXcQ")
+		return err
+	})
+	if err == nil {
+		t.Fatal("expected the resolve to time out")
+	}
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("yt-dlp was not killed at the deadline, took %v", elapsed)
+	}
+}
+
+func TestResolverPoolLimit(t *testing.T) {
+	pool := NewResolverPool(1, time.Minute)
+	release := make(chan struct{})
+	go pool.Do(context.Background(), func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	defer close(release)
+
+	waitFor(t, func() bool { return len(pool.slots) == 1 })
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := pool.Do(ctx, func(ctx context.Context) error {
+		t.Error("ran without a free slot")
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
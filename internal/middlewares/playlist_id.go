@@ -2,18 +2,49 @@ package middlewares
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/btmxh/plst4/internal/db"
 	"github.com/btmxh/plst4/internal/errs"
+	"github.com/btmxh/plst4/internal/services"
 	"github.com/btmxh/plst4/internal/stores"
 	"github.com/gin-gonic/gin"
 )
 
 var InvalidPlaylistIdError = errors.New("Invalid playlist ID.")
 
+func PlaylistAccessCookieName(playlist int) string {
+	return fmt.Sprintf("PlaylistAccess-%d", playlist)
+}
+
+func SetPlaylistAccessCookie(c *gin.Context, playlist int, signedToken string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(PlaylistAccessCookieName(playlist), signedToken, int(services.PlaylistAccessTimeout.Seconds()), "/", "", !gin.IsDebugging(), true)
+}
+
+// Empty if the visitor has not entered the access code of playlist.
+func GetPlaylistAccessToken(c *gin.Context, playlist int) string {
+	token, err := c.Cookie(PlaylistAccessCookieName(playlist))
+	if err != nil {
+		return ""
+	}
+
+	return token
+}
+
 func PlaylistIdMiddleware() gin.HandlerFunc {
+	return playlistIdMiddleware(true)
+}
+
+// Like PlaylistIdMiddleware, but lets visitors without the access code of the
+// playlist through, for the routes asking them for it.
+func PlaylistIdWithoutAccessMiddleware() gin.HandlerFunc {
+	return playlistIdMiddleware(false)
+}
+
+func playlistIdMiddleware(checkAccess bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		handler := errs.NewGinErrorHandler(ctx, "Error")
 		id := ctx.Param("id")
@@ -40,19 +71,33 @@ func PlaylistIdMiddleware() gin.HandlerFunc {
 		}
 		defer tx.Rollback()
 
-		var dummy int
-		var hasRow bool
-		if tx.QueryRow("SELECT 1 FROM playlists WHERE id = $1", idInt).Scan(&hasRow, &dummy) {
+		// private playlists are reported as missing to those who cannot view them
+		canView, hasErr := services.CanViewPlaylist(tx, stores.GetUsername(ctx), idInt)
+		if hasErr {
 			ctx.Abort()
 			return
 		}
 
-		if !hasRow {
+		if !canView {
 			handler.PublicError(http.StatusNotFound, InvalidPlaylistIdError)
 			ctx.Abort()
 			return
 		}
 
+		if checkAccess {
+			granted, hasErr := services.HasPlaylistAccess(tx, stores.GetUsername(ctx), idInt, GetPlaylistAccessToken(ctx, idInt))
+			if hasErr {
+				ctx.Abort()
+				return
+			}
+
+			if !granted {
+				handler.PublicError(http.StatusForbidden, services.AccessCodeRequiredError)
+				ctx.Abort()
+				return
+			}
+		}
+
 		if tx.Commit() {
 			ctx.Abort()
 			return
@@ -1,7 +1,6 @@
 package routes
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
 	"html/template"
@@ -20,6 +19,11 @@ import (
 var ErrMissingItemId = errors.New("Missing itemId")
 
 func MediasRouter(g *gin.RouterGroup) {
+	// usage of the server is only shown to registered users
+	g.GET("/cache/stats", RenderErrorMiddleware(), middlewares.MustAuthMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, media.GetResolveCacheStats())
+	})
+
 	idGroup := g.Group("/:id/")
 	idGroup.Use(ToastErrorMiddleware())
 	idGroup.Use(middlewares.MediaIdMiddleware())
@@ -31,79 +35,41 @@ func updateMediaMetadataHandler(ctx *gin.Context) {
 	handler := errs.NewGinErrorHandler(ctx, "Update error")
 	id := stores.GetMediaId(ctx)
 
-	tx := db.BeginTx(handler)
-	if tx == nil {
+	check, hasRow, hasErr := services.ResolveMediaCheck(ctx.Request.Context(), handler, id)
+	if hasErr {
 		return
-	}
-	defer tx.Rollback()
-
-	// get media URL from db
-	url, hasRow, hasErr := services.GetMediaUrl(tx, id)
-	if !hasRow {
+	} else if !hasRow {
 		handler.PublicError(http.StatusNotFound, media.ErrMediaNotFound)
 		return
-	} else if hasErr {
-		return
 	}
 
-	object, err := media.ProcessURL(url)
-	if err != nil {
-		handler.PublicError(http.StatusUnprocessableEntity, err)
+	tx := db.BeginTx(handler)
+	if tx == nil {
 		return
 	}
+	defer tx.Rollback()
 
-	canonMedia, err := object.Canonicalize(ctx.Request.Context())
-	if err != nil {
-		handler.PublicError(http.StatusUnprocessableEntity, err)
+	result, hasErr := services.CheckMedia(tx, check)
+	if hasErr {
 		return
 	}
 
-	resolvedMedia, err := canonMedia.Resolve(ctx.Request.Context())
-	if err != nil {
-		handler.PublicError(http.StatusUnprocessableEntity, err)
+	callback, hasErr := services.NotifyMediaPlaylists(tx, result.Id)
+	if hasErr {
 		return
 	}
 
-	resolvedMediaSingle, ok := resolvedMedia.(media.ResolvedMediaObjectSingle)
-	if !ok {
-		handler.PublicError(http.StatusUnprocessableEntity, errors.New("Media no longer a single media entry"))
-	}
-
-	hasErr = services.UpdateMedia(tx, id, resolvedMediaSingle)
-	if hasErr {
-		handler.PublicError(http.StatusInternalServerError, errors.New("Failed to update media"))
+	// the availability status is kept even if resolving failed
+	if tx.Commit() {
 		return
 	}
-	tx.Commit()
-
-	// announce playlist refresh to all playlists with medias of id `id`
-	go func() {
-		handler := errs.NewLogErrorHandler("Announce playlist refresh error", func(err error) error { return nil })
 
-		tx := db.BeginTx(handler)
-		if tx == nil {
-			return
-		}
-		defer tx.Rollback()
+	callback()
 
-		// write SQL query to query playlists containing playlist items
-		// with the media id = id
-		var rows *sql.Rows
-		if tx.Query(&rows, "SELECT DISTINCT playlist FROM playlist_items WHERE media = $1", id) {
-		}
-
-		for rows.Next() {
-			var id int
-			err := rows.Scan(&id)
-			if err != nil {
-				handler.PrivateError(err)
-			}
-
-			services.WebSocketPlaylistEvent(id, services.PlaylistChanged)
-		}
-
-		tx.Commit()
-	}()
+	if result.ResolveErr != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, result.ResolveErr)
+		return
+	}
 
-	Toast(ctx, html.ToastInfo, "Media metadata updated", template.HTML(template.HTMLEscapeString(fmt.Sprintf("Metadata of media at URL '%s' updated.", url))))
+	Toast(ctx, html.ToastInfo, "Media metadata updated", template.HTML(template.HTMLEscapeString(fmt.Sprintf("Metadata of media at URL '%s' updated.", check.URL))))
 }
@@ -1,13 +1,18 @@
 package routes
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"regexp"
 	"slices"
 	"strconv"
@@ -27,12 +32,34 @@ import (
 var watchTemplate = getTemplate("watch", "templates/watch.tmpl")
 var playlistWatchTmpl = getTemplate("watch", "templates/playlists/watch.tmpl")
 var playlistWatchInvalidTmpl = getTemplate("watch", "templates/playlists/watch_invalid.tmpl")
+var playlistWatchLockedTmpl = getTemplate("watch", "templates/playlists/watch_locked.tmpl")
 var playlistQueryResult = getTemplate("playlist_query_result", "templates/playlists/playlist_query_result.tmpl")
 
 var playlistNameRegex = regexp.MustCompile(`^.{4,100}$`)
 var noCurrentMediaError = errors.New("No currently playing media.")
 var invalidItemError = errors.New("Invalid playlist item ID.")
 var invalidFormData = errors.New("Invalid form data.")
+var emptySearchQueryError = errors.New("Search query must not be empty.")
+var unsupportedSearchError = errors.New("Searching is not supported on this platform.")
+var searchFailedError = errors.New("Unable to search for media, please try again later.")
+var invalidJobError = errors.New("Invalid job ID.")
+var emptyBatchError = errors.New("No URLs to add.")
+var batchTooLargeError = fmt.Errorf("At most %d URLs can be added at once.", maxBatchUrls)
+var batchFileTooLargeError = fmt.Errorf("URL file is too large, the limit is %d MiB.", maxBatchFileSize>>20)
+var invalidMergeSourceError = errors.New("Invalid playlist to merge, expected a playlist ID or link.")
+var mergeSourceNotFoundError = errors.New("Playlist to merge not found.")
+
+// playlist IDs, optionally as part of a watch or playlist link
+var playlistRefRegex = regexp.MustCompile(`^(?:.*/(?:watch|playlists)/)?(\d+)/?$`)
+
+// number of candidates shown in the search result picker
+const mediaSearchLimit = 5
+
+// limits of batch adds
+const (
+	maxBatchUrls     = 1000
+	maxBatchFileSize = 1 << 20
+)
 
 func getCheckedItems(c *gin.Context, handler errs.ErrorHandler) (ids []int, hasErr bool) {
 	var args map[string][]string
@@ -76,9 +103,14 @@ func WatchRouter(g *gin.RouterGroup) {
 
 	watchPageRouter := g.Group("/:id/")
 	watchPageRouter.Use(RenderErrorMiddleware())
-	watchPageRouter.Use(middlewares.PlaylistIdMiddleware())
+	watchPageRouter.Use(middlewares.PlaylistIdWithoutAccessMiddleware())
 	watchPageRouter.GET("/", playlistWatch)
 
+	accessGroup := g.Group("/:id/")
+	accessGroup.Use(ToastErrorMiddleware())
+	accessGroup.Use(middlewares.PlaylistIdWithoutAccessMiddleware())
+	accessGroup.POST("/access", playlistEnterAccessCode)
+
 	idGroup := g.Group("/:id/")
 	idGroup.Use(ToastErrorMiddleware())
 	idGroup.Use(middlewares.PlaylistIdMiddleware())
@@ -104,9 +136,19 @@ func WatchRouter(g *gin.RouterGroup) {
 			HxRedirect(c, "/watch")
 		}
 	})
+	ownerGroup.PATCH("/controller/visibility", playlistSetVisibility)
+	managerGroup.PATCH("/controller/access-code", playlistSetAccessCode)
+	ownerGroup.PATCH("/controller/details", playlistSetDetails)
+	ownerGroup.POST("/controller/cover", playlistUploadCover)
+	ownerGroup.PATCH("/controller/cover/:media-id", playlistSetCoverMedia)
+	ownerGroup.DELETE("/controller/cover", playlistRemoveCover)
 	idGroup.GET("/queue", playlistWatchQueue)
 	idGroup.GET("/queue/current", playlistWatchQueueCurrent)
 	managerGroup.POST("/queue/add", playlistAdd)
+	managerGroup.POST("/queue/batch", playlistBatchAdd)
+	managerGroup.POST("/queue/import", playlistImport)
+	managerGroup.POST("/queue/merge", playlistMerge)
+	managerGroup.GET("/queue/search", playlistSearchMedia)
 	managerGroup.DELETE("/queue/delete", playlistItemsDelete)
 	managerGroup.PATCH("/queue/goto/:item-id", playlistGoto)
 	loggedInGroup.POST("/queue/nextreq", playlistNextRequest)
@@ -114,6 +156,8 @@ func WatchRouter(g *gin.RouterGroup) {
 	managerGroup.POST("/queue/next", playlistNext)
 	managerGroup.POST("/queue/up", playlistMoveUp)
 	managerGroup.POST("/queue/down", playlistMoveDown)
+	idGroup.GET("/jobs", playlistJobs)
+	managerGroup.POST("/jobs/:job-id/cancel", playlistJobCancel)
 	idGroup.GET("/managers", playlistManagers)
 	ownerGroup.POST("/managers/add", playlistManagerAdd)
 	ownerGroup.DELETE("/managers/delete", playlistManagerDelete)
@@ -122,10 +166,14 @@ func WatchRouter(g *gin.RouterGroup) {
 
 func PlaylistRouter(g *gin.RouterGroup) {
 	g.GET("/search", search)
+	g.GET("/:id/export", RenderErrorMiddleware(), middlewares.PlaylistIdMiddleware(), playlistExport)
+	// covers are shown in search results, even to those lacking the access code
+	g.GET("/:id/cover", RenderErrorMiddleware(), middlewares.PlaylistIdWithoutAccessMiddleware(), playlistCover)
 	mustAuth := g.Group("")
 	mustAuth.Use(ToastErrorMiddleware())
 	mustAuth.Use(middlewares.MustAuthMiddleware())
 	mustAuth.POST("/new", newPlaylist)
+	mustAuth.POST("/import", importPlaylist)
 
 	idGroup := mustAuth.Group("/:id/")
 	idGroup.Use(middlewares.PlaylistIdMiddleware())
@@ -139,6 +187,7 @@ func PlaylistRouter(g *gin.RouterGroup) {
 			HxRefresh(c)
 		}
 	})
+	idGroup.POST("/copy", playlistCopy)
 }
 
 var invalidOffsetError = errors.New("Invalid offset.")
@@ -150,6 +199,7 @@ func search(c *gin.Context) {
 	username := stores.GetUsername(c)
 
 	query := strings.ToLower(c.Query("query"))
+	tag := services.NormalizeTag(c.Query("tag"))
 
 	filter, err := services.ParsePlaylistFilter(c.Query("filter"))
 	if err != nil {
@@ -157,6 +207,12 @@ func search(c *gin.Context) {
 		return
 	}
 
+	sort, err := services.ParsePlaylistSort(c.Query("sort"))
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
+		return
+	}
+
 	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
 	if err != nil {
 		handler.PrivateError(err)
@@ -170,7 +226,7 @@ func search(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	playlists, hasErr := services.SearchPlaylists(tx, username, query, filter, offset)
+	playlists, hasErr := services.SearchPlaylists(tx, username, query, tag, filter, sort, offset)
 	if hasErr {
 		return
 	}
@@ -275,11 +331,284 @@ func playlistDeleteCommon(c *gin.Context) (hasErr bool) {
 	}
 	defer tx.Rollback()
 
+	// only owners actually delete the playlist, and its cover with it
+	isOwner, hasErr := services.IsPlaylistOwner(tx, username, id)
+	if hasErr {
+		return
+	}
+
+	coverFile, _, _, hasErr := services.GetPlaylistCover(tx, id)
+	if hasErr {
+		return
+	}
+
 	if services.DeletePlaylist(tx, username, id) {
 		return
 	}
 
-	return tx.Commit()
+	if tx.Commit() {
+		return true
+	}
+
+	if isOwner && coverFile.Valid {
+		services.RemoveCoverFile(coverFile.String)
+	}
+
+	return false
+}
+
+func playlistSetVisibility(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist visibility error")
+	id := stores.GetPlaylistId(c)
+
+	visibility, err := services.ParsePlaylistVisibility(c.PostForm("visibility"))
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	disconnect, hasErr := services.SetPlaylistVisibility(tx, id, visibility)
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	disconnect()
+	services.WebSocketPlaylistEvent(id, services.PlaylistChanged)
+	Toast(c, html.ToastInfo, "Visibility changed", html.StringAsHTML(fmt.Sprintf("Playlist is now %s", visibility)))
+}
+
+func playlistEnterAccessCode(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Access code error")
+	id := stores.GetPlaylistId(c)
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	signedToken, hasErr := services.EnterPlaylistAccessCode(tx, id, c.PostForm("access-code"), c.ClientIP())
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	middlewares.SetPlaylistAccessCookie(c, id, signedToken)
+	HxRefresh(c)
+}
+
+func playlistSetAccessCode(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Access code error")
+	id := stores.GetPlaylistId(c)
+	code := c.PostForm("access-code")
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	disconnect, hasErr := services.SetPlaylistAccessCode(tx, id, code)
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	disconnect()
+	services.WebSocketPlaylistEvent(id, services.PlaylistChanged)
+	if code == "" {
+		Toast(c, html.ToastInfo, "Access code removed", "Anyone who can view this playlist can now watch it.")
+	} else {
+		Toast(c, html.ToastInfo, "Access code changed", "Viewers who entered the previous access code were disconnected.")
+	}
+}
+
+func playlistSetDetails(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist details error")
+	id := stores.GetPlaylistId(c)
+
+	tags, err := services.ParsePlaylistTags(c.PostForm("tags"))
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	if services.SetPlaylistDetails(tx, id, strings.TrimSpace(c.PostForm("description")), tags) {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	services.WebSocketPlaylistEvent(id, services.PlaylistChanged)
+	Toast(c, html.ToastInfo, "Details saved", "Playlist description and tags were updated.")
+}
+
+func playlistCover(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist cover error")
+	id := stores.GetPlaylistId(c)
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	coverFile, coverMedia, currentMedia, hasErr := services.GetPlaylistCover(tx, id)
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	if !coverFile.Valid {
+		c.Redirect(http.StatusFound, services.PlaylistCoverURL(id, coverFile, coverMedia, currentMedia))
+		return
+	}
+
+	// cover URLs are versioned by file name, but may belong to private playlists
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(thumbnailMaxAge.Seconds())))
+	c.File(services.CoverPath(coverFile.String))
+}
+
+func playlistUploadCover(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist cover error")
+	id := stores.GetPlaylistId(c)
+
+	header, err := c.FormFile("cover-file")
+	if err != nil {
+		handler.PrivateError(err)
+		handler.PublicError(http.StatusUnprocessableEntity, invalidFormData)
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		handler.PrivateError(err)
+		handler.PublicError(http.StatusUnprocessableEntity, invalidFormData)
+		return
+	}
+	defer file.Close()
+
+	name, err := services.SaveCoverFile(id, file)
+	if err != nil {
+		handler.PrivateError(err)
+		if errors.Is(err, services.ImageTooLargeError) {
+			handler.PublicError(http.StatusUnprocessableEntity, services.ImageTooLargeError)
+		} else if errors.Is(err, services.InvalidCoverError) {
+			handler.PublicError(http.StatusUnprocessableEntity, services.InvalidCoverError)
+		} else {
+			handler.PublicError(http.StatusInternalServerError, db.GenericError)
+		}
+		return
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			services.RemoveCoverFile(name)
+		}
+	}()
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	removeOld, hasErr := services.SetPlaylistCoverFile(tx, id, name)
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	committed = true
+	removeOld()
+	services.WebSocketPlaylistEvent(id, services.PlaylistChanged)
+	Toast(c, html.ToastInfo, "Cover changed", "The uploaded image is now the playlist cover.")
+}
+
+func playlistSetCoverMedia(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist cover error")
+	id := stores.GetPlaylistId(c)
+
+	mediaId, err := strconv.Atoi(c.Param("media-id"))
+	if err != nil {
+		handler.PrivateError(err)
+		handler.PublicError(http.StatusNotFound, services.CoverMediaNotInPlaylistError)
+		return
+	}
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	removeOld, hasErr := services.SetPlaylistCoverMedia(tx, id, mediaId)
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	removeOld()
+	services.WebSocketPlaylistEvent(id, services.PlaylistChanged)
+	Toast(c, html.ToastInfo, "Cover changed", "The media thumbnail is now the playlist cover.")
+}
+
+func playlistRemoveCover(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist cover error")
+	id := stores.GetPlaylistId(c)
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	removeOld, hasErr := services.RemovePlaylistCover(tx, id)
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	removeOld()
+	services.WebSocketPlaylistEvent(id, services.PlaylistChanged)
+	Toast(c, html.ToastInfo, "Cover removed", "The current media is shown instead.")
 }
 
 func playlistWatch(c *gin.Context) {
@@ -298,11 +627,21 @@ func playlistWatch(c *gin.Context) {
 		return
 	}
 
+	granted, hasErr := services.HasPlaylistAccess(tx, stores.GetUsername(c), id, middlewares.GetPlaylistAccessToken(c, id))
+	if hasErr {
+		return
+	}
+
 	if tx.Commit() {
 		return
 	}
 
-	html.RenderGin(playlistWatchTmpl, c, "layout", gin.H{
+	tmpl := playlistWatchTmpl
+	if !granted {
+		tmpl = playlistWatchLockedTmpl
+	}
+
+	html.RenderGin(tmpl, c, "layout", gin.H{
 		"Id":    id,
 		"Title": name,
 	})
@@ -420,8 +759,21 @@ func playlistWatchController(c *gin.Context) {
 	var owner string
 	var createdTimestamp time.Time
 	var current sql.NullInt32
+	var visibility services.PlaylistVisibility
+	var hasAccessCode bool
+	var description string
 	var hasRow bool
-	if tx.QueryRow("SELECT name, owner_username, created_timestamp, current FROM playlists WHERE id = $1", id).Scan(&hasRow, &name, &owner, &createdTimestamp, &current) {
+	if tx.QueryRow("SELECT name, owner_username, created_timestamp, current, visibility, access_code_hash IS NOT NULL, description FROM playlists WHERE id = $1", id).Scan(&hasRow, &name, &owner, &createdTimestamp, &current, &visibility, &hasAccessCode, &description) {
+		return
+	}
+
+	tags, hasErr := services.GetPlaylistTags(tx, id)
+	if hasErr {
+		return
+	}
+
+	coverFile, coverMedia, currentMedia, hasErr := services.GetPlaylistCover(tx, id)
+	if hasErr {
 		return
 	}
 
@@ -437,6 +789,12 @@ func playlistWatchController(c *gin.Context) {
 		"Owner":            owner,
 		"CreatedTimestamp": createdTimestamp,
 		"IsManager":        isManager,
+		"Visibility":       visibility,
+		"HasAccessCode":    hasAccessCode,
+		"Description":      description,
+		"Tags":             tags,
+		"Cover":            services.PlaylistCoverURL(id, coverFile, coverMedia, currentMedia),
+		"HasCover":         coverFile.Valid || coverMedia.Valid,
 	}
 
 	if current.Valid {
@@ -447,7 +805,9 @@ func playlistWatchController(c *gin.Context) {
 		var altTitle string
 		var altArtist string
 		var duration int
+		var start, end int
 		var url string
+		var thumbnail string
 		var mediaAddTimestamp time.Time
 		var itemAddTimestamp time.Time
 		if tx.QueryRow(`
@@ -459,13 +819,16 @@ func playlistWatchController(c *gin.Context) {
 				COALESCE(a.alt_title, m.title),
 				COALESCE(a.alt_artist, m.artist),
 				m.duration,
+				COALESCE(i.start_offset, 0),
+				COALESCE(i.end_offset, 0),
 				m.url,
+				COALESCE(m.thumbnail, $2),
 				m.add_timestamp,
 				i.add_timestamp
 			FROM playlist_items i
 			JOIN medias m ON i.media = m.id
 			LEFT JOIN alt_metadata a ON a.media = m.id AND a.playlist = i.playlist
-			WHERE i.id = $1`, current).Scan(nil, &mediaId, &mediaType, &title, &artist, &altTitle, &altArtist, &duration, &url, &mediaAddTimestamp, &itemAddTimestamp) {
+			WHERE i.id = $1`, current, media.DefaultThumbnail).Scan(nil, &mediaId, &mediaType, &title, &artist, &altTitle, &altArtist, &duration, &start, &end, &url, &thumbnail, &mediaAddTimestamp, &itemAddTimestamp) {
 			return
 		}
 		args["Media"] = gin.H{
@@ -475,10 +838,12 @@ func playlistWatchController(c *gin.Context) {
 			"URL":               url,
 			"Title":             altTitle,
 			"Artist":            altArtist,
-			"ThumbnailUrl":      media.GetThumbnail(sql.NullString{String: url, Valid: true}),
+			"ThumbnailUrl":      thumbnail,
 			"OriginalTitle":     title,
 			"OriginalArtist":    artist,
 			"Duration":          time.Duration(duration) * time.Second,
+			"Start":             time.Duration(start) * time.Second,
+			"End":               time.Duration(end) * time.Second,
 			"MediaAddTimestamp": mediaAddTimestamp,
 			"ItemAddTimestamp":  itemAddTimestamp,
 		}
@@ -491,9 +856,8 @@ func playlistWatchController(c *gin.Context) {
 	html.RenderGin(playlistWatchTmpl, c, "controller", args)
 }
 
-func playlistResolveAndAdd(ctx context.Context, handler errs.ErrorHandler, playlist int, mediaObj media.MediaObject, pos services.PlaylistAddPosition) (msg template.HTML, hasErr bool) {
-	isSingle := true
-	canonMedia, err := mediaObj.Canonicalize(ctx)
+func playlistResolveAndAdd(ctx context.Context, handler errs.ErrorHandler, playlist int, mediaObj media.MediaObject, trim media.Trim, pos services.PlaylistAddPosition) (msg template.HTML, hasErr bool) {
+	canonMedia, err := media.Canonicalize(ctx, mediaObj)
 	if err != nil {
 		handler.PublicError(http.StatusUnprocessableEntity, err)
 		return msg, true
@@ -501,132 +865,231 @@ func playlistResolveAndAdd(ctx context.Context, handler errs.ErrorHandler, playl
 
 	tx := db.BeginTx(handler)
 	if tx == nil {
-		return
+		return msg, true
 	}
 	defer tx.Rollback()
 
-	var resolvedMedia media.ResolvedMediaObject
-	resolvedMedia, hasRow, hasErr := services.GetResolvedMedia(tx, canonMedia.URL().String())
-	var mediaIds []int
+	resolvedMedia, mediaIds, hasErr := services.ResolveMedias(ctx, tx, canonMedia)
 	if hasErr {
 		return msg, true
 	}
 
-	if !hasRow {
-		resolvedMedia, err = canonMedia.Resolve(ctx)
-		if err != nil {
-			handler.PublicError(http.StatusUnprocessableEntity, err)
-			return msg, true
-		}
-
-		var resolvedMediaSingle media.ResolvedMediaObjectSingle
-		resolvedMediaSingle, isSingle = resolvedMedia.(media.ResolvedMediaObjectSingle)
-		if isSingle {
-			if id, hasErr := services.AddMedia(tx, resolvedMediaSingle); !hasErr {
-				mediaIds = append(mediaIds, id)
-			} else {
-				return msg, true
-			}
-		} else {
-			for _, media := range resolvedMedia.ChildEntries() {
-				if id, hasErr := services.AddMedia(tx, media); !hasErr {
-					mediaIds = append(mediaIds, id)
-				} else {
-					return msg, true
-				}
-			}
-		}
-	} else {
-		id, hasRow, hasErr := services.GetMediaId(tx, canonMedia.URL().String())
-		if hasErr {
-			return msg, true
-		}
+	itemIds, hasErr := services.InsertPlaylistItems(tx, playlist, mediaIds, pos)
+	if hasErr {
+		return msg, true
+	}
 
-		if !hasRow {
-			panic("should not happen")
-		}
+	_, isSingle := resolvedMedia.(media.ResolvedMediaObjectSingle)
+	if isSingle && trim != (media.Trim{}) && services.SetPlaylistItemTrim(tx, itemIds[0], trim) {
+		return msg, true
+	}
 
-		mediaIds = []int{id}
+	if tx.Commit() {
+		return msg, true
 	}
 
-	var current sql.NullInt32
-	if pos == services.QueueNext {
-		if tx.QueryRow("SELECT current FROM playlists WHERE id = $1", playlist).Scan(nil, &current) {
-			return
-		}
-		if !current.Valid {
-			pos = services.AddToEnd
-		}
+	if isSingle {
+		msg = html.StringAsHTML(fmt.Sprintf("Media %s - %s added to playlist", resolvedMedia.Title(), resolvedMedia.Artist()))
+	} else {
+		msg = html.StringAsHTML(fmt.Sprintf("Media list %s - %s added to playlist", resolvedMedia.Title(), resolvedMedia.Artist()))
 	}
 
-	var begin int
-	delta := services.PlaylistAddOrderGap
-	if pos != services.QueueNext {
-		var minOrder int
-		var maxOrder int
-		if tx.QueryRow("SELECT COALESCE(MIN(item_order), 0), COALESCE(MAX(item_order), 0) FROM playlist_items WHERE playlist = $1", playlist).Scan(&hasRow, &minOrder, &maxOrder) {
-			return
-		}
+	services.WebSocketPlaylistEvent(playlist, services.PlaylistChanged)
+	return msg, false
+}
 
-		if hasRow {
-			if pos == services.AddToStart {
-				begin = minOrder - delta*len(mediaIds)
-			} else {
-				begin = maxOrder + delta
-			}
-		} else {
-			begin = 0
+func playlistAdd(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist add error")
+	id := stores.GetPlaylistId(c)
+
+	pos, err := services.ParsePlaylistAddPosition(c.PostForm("position"))
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	url := c.PostForm("url")
+	canonInfo, err := media.ProcessURL(url)
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	choiceMade := false
+	if withList, ok := canonInfo.(media.MediaObjectWithList); ok {
+		switch c.PostForm("list-mode") {
+		case "single":
+			canonInfo = withList.SingleMedia()
+		case "list":
+			canonInfo = withList.MediaList()
+		default:
+			// let the user choose first
+			HxNoswap(c)
+			html.RenderGin(playlistWatchTmpl, c, "add-list-choice", gin.H{"Id": id})
+			return
 		}
-	} else {
-		prev := int(current.Int32)
-		var prevOrder, nextOrder int
-		prevOrder, hasErr = services.GetPlaylistItemOrder(tx, prev)
-		if hasErr {
-			return msg, true
+		choiceMade = true
+	}
+
+	// the choice prompt is answered once the media is added
+	clearChoice := func() {
+		if choiceMade {
+			html.RenderGin(playlistWatchTmpl, c, "add-list-choice", gin.H{"Id": id, "Clear": true})
 		}
+	}
 
-		slog.Info("Adding playlist item between...", "prev", prev, "prev_order", prevOrder)
-		var next int
-		next, nextOrder, hasRow, hasErr = services.GetNextPlaylistItem(tx, playlist, prevOrder)
+	wsId := c.PostForm("websocket-id")
+	if wsId == "" {
+		msg, hasErr := playlistResolveAndAdd(c.Request.Context(), handler, id, canonInfo, media.ParseTrim(url), pos)
 		if hasErr {
-			return msg, true
+			return
 		}
 
-		if !hasRow {
-			slog.Info("and nothing!")
-			delta = services.PlaylistAddOrderGap
-			begin = prevOrder + delta
-		} else {
-			slog.Info("and...", "next", next, "next_order", nextOrder)
-			slog.Info("local rebalancing", "playlist", playlist, "prev_order", prevOrder, "next_order", nextOrder, "prev", prev, "next", next, "len", len(mediaIds))
-			begin, delta, hasErr = services.LocalRebalance(tx, playlist, prevOrder, nextOrder, prev, len(mediaIds))
-			if hasErr {
-				return msg, true
+		clearChoice()
+		Toast(c, html.ToastInfo, "Media added successfully", msg)
+	} else {
+		tx := db.BeginTx(handler)
+		if tx == nil {
+			return
+		}
+		defer tx.Rollback()
+
+		inputs := []services.JobInput{{URL: url, ListMode: c.PostForm("list-mode")}}
+		if _, hasErr := services.CreateJob(tx, id, stores.GetUsername(c), wsId, pos, inputs); hasErr {
+			return
+		}
+
+		if services.WebSocketJobsChanged(tx, id) || tx.Commit() {
+			return
+		}
+
+		services.NotifyJobCreated()
+		clearChoice()
+		Toast(c, html.ToastInfo, "Adding new media", template.HTML(template.HTMLEscapeString(fmt.Sprintf("Adding media with URL %s to playlist...", url))))
+	}
+}
+
+// Read the URLs of a batch add, one per line, from both the text area and the
+// uploaded file (if any). Empty lines and #-comments are skipped. Inputs keep
+// the line they were read from, counted from the start of their source.
+func getBatchInputs(c *gin.Context, handler errs.ErrorHandler) (inputs []services.JobInput, hasErr bool) {
+	sources := []io.Reader{strings.NewReader(c.PostForm("urls"))}
+	if header, err := c.FormFile("urls-file"); err == nil {
+		if header.Size > maxBatchFileSize {
+			handler.PublicError(http.StatusRequestEntityTooLarge, batchFileTooLargeError)
+			return nil, true
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			handler.PrivateError(err)
+			handler.PublicError(http.StatusUnprocessableEntity, invalidFormData)
+			return nil, true
+		}
+		defer file.Close()
+
+		sources = append(sources, file)
+	}
+
+	for _, source := range sources {
+		scanner := bufio.NewScanner(source)
+		for lineNumber := 1; scanner.Scan(); lineNumber++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
 			}
+
+			// URLs referring to both a media and a media list only add the
+			// media, lists must be added one by one
+			inputs = append(inputs, services.JobInput{URL: line, Line: lineNumber})
+		}
+
+		if err := scanner.Err(); err != nil {
+			handler.PrivateError(err)
+			handler.PublicError(http.StatusUnprocessableEntity, invalidFormData)
+			return nil, true
 		}
 	}
 
-	slog.Info("Adding media to playlist", "playlist", playlist, "mediaIds", mediaIds, "begin", begin, "delta", delta)
-	if _, hasErr = services.AddPlaylistItems(tx, playlist, mediaIds, begin, delta); hasErr {
+	if len(inputs) == 0 {
+		handler.PublicError(http.StatusUnprocessableEntity, emptyBatchError)
+		return nil, true
+	}
+
+	if len(inputs) > maxBatchUrls {
+		handler.PublicError(http.StatusUnprocessableEntity, batchTooLargeError)
+		return nil, true
+	}
+
+	return inputs, false
+}
+
+func playlistBatchAdd(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist batch add error")
+	id := stores.GetPlaylistId(c)
+
+	pos, err := services.ParsePlaylistAddPosition(c.PostForm("position"))
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	if tx.Commit() {
+	inputs, hasErr := getBatchInputs(c, handler)
+	if hasErr {
 		return
 	}
 
-	if isSingle {
-		msg = html.StringAsHTML(fmt.Sprintf("Media list %s - %s added to playlist", resolvedMedia.Title(), resolvedMedia.Artist()))
-	} else {
-		msg = html.StringAsHTML(fmt.Sprintf("Media %s - %s added to playlist", resolvedMedia.Title(), resolvedMedia.Artist()))
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
 	}
+	defer tx.Rollback()
 
-	services.WebSocketPlaylistEvent(playlist, services.PlaylistChanged)
-	return msg, false
+	if _, hasErr := services.CreateJob(tx, id, stores.GetUsername(c), c.PostForm("websocket-id"), pos, inputs); hasErr {
+		return
+	}
+
+	if services.WebSocketJobsChanged(tx, id) || tx.Commit() {
+		return
+	}
+
+	services.NotifyJobCreated()
+	Toast(c, html.ToastInfo, "Adding new media", html.StringAsHTML(fmt.Sprintf("Adding %d URL(s) to playlist...", len(inputs))))
 }
 
-func playlistAdd(c *gin.Context) {
-	handler := errs.NewGinErrorHandler(c, "Playlist add error")
+func getPlaylistFile(c *gin.Context, handler errs.ErrorHandler) (playlist services.ImportedPlaylist, fileName string, hasErr bool) {
+	header, err := c.FormFile("playlist-file")
+	if err != nil {
+		handler.PrivateError(err)
+		handler.PublicError(http.StatusUnprocessableEntity, invalidFormData)
+		return playlist, "", true
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		handler.PrivateError(err)
+		handler.PublicError(http.StatusUnprocessableEntity, invalidFormData)
+		return playlist, "", true
+	}
+	defer file.Close()
+
+	if header.Size > services.MaxImportFileSize {
+		handler.PublicError(http.StatusRequestEntityTooLarge, services.ImportFileTooLargeError)
+		return playlist, "", true
+	}
+
+	playlist, err = services.ParsePlaylistFile(header.Filename, file)
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
+		return playlist, "", true
+	}
+
+	return playlist, header.Filename, false
+}
+
+// Append the medias of an uploaded playlist file to the playlist.
+func playlistImport(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist import error")
 	id := stores.GetPlaylistId(c)
 
 	pos, err := services.ParsePlaylistAddPosition(c.PostForm("position"))
@@ -635,32 +1098,307 @@ func playlistAdd(c *gin.Context) {
 		return
 	}
 
-	url := c.PostForm("url")
-	canonInfo, err := media.ProcessURL(url)
+	playlist, _, hasErr := getPlaylistFile(c, handler)
+	if hasErr {
+		return
+	}
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	if services.AddImportedMedias(tx, playlist.Medias) {
+		return
+	}
+
+	if _, hasErr := services.CreateJob(tx, id, stores.GetUsername(c), c.PostForm("websocket-id"), pos, playlist.Inputs); hasErr {
+		return
+	}
+
+	if services.WebSocketJobsChanged(tx, id) || tx.Commit() {
+		return
+	}
+
+	services.NotifyJobCreated()
+	Toast(c, html.ToastInfo, "Adding new media", html.StringAsHTML(fmt.Sprintf("Importing %d item(s) to playlist...", len(playlist.Inputs))))
+}
+
+// Create a playlist from an uploaded playlist file, named after the form
+// field, the playlist in the file or the file itself, in that order.
+func importPlaylist(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist import error")
+	username := stores.GetUsername(c)
+
+	playlist, fileName, hasErr := getPlaylistFile(c, handler)
+	if hasErr {
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		name = playlist.Name
+	}
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+
+	if !playlistNameRegex.MatchString(name) {
+		handler.PublicError(http.StatusUnprocessableEntity, invalidTitleError)
+		return
+	}
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	id, hasErr := services.CreatePlaylist(tx, username, name)
+	if hasErr {
+		return
+	}
+
+	// the importer watches the new playlist by the time the job is done, so
+	// the report is sent to the whole playlist
+	if services.AddImportedMedias(tx, playlist.Medias) {
+		return
+	}
+
+	if _, hasErr := services.CreateJob(tx, id, username, "", services.AddToEnd, playlist.Inputs); hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	services.NotifyJobCreated()
+	HxRedirect(c, "/watch/"+strconv.Itoa(id))
+}
+
+// Copy a playlist for the current user, named "<name> (copy)" unless a name
+// is given.
+func playlistCopy(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist copy error")
+	username := stores.GetUsername(c)
+	id := stores.GetPlaylistId(c)
+
+	name, err := HxPrompt(c)
 	if err != nil {
-		handler.PublicError(http.StatusUnprocessableEntity, err)
+		handler.PrivateError(err)
+		handler.PublicError(http.StatusUnprocessableEntity, invalidTitleError)
 		return
 	}
 
-	wsId := c.PostForm("websocket-id")
-	if wsId == "" {
-		msg, hasErr := playlistResolveAndAdd(c.Request.Context(), handler, id, canonInfo, pos)
-		if hasErr {
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	if name = strings.TrimSpace(name); name == "" {
+		var sourceName string
+		if tx.QueryRow("SELECT name FROM playlists WHERE id = $1", id).Scan(nil, &sourceName) {
 			return
 		}
 
-		Toast(c, html.ToastInfo, "Media added successfully", msg)
-	} else {
-		go func() {
-			msg, hasErr := playlistResolveAndAdd(context.Background(), services.NewWebSocketErrorHandler("Unable to add media to playlist", wsId), id, canonInfo, pos)
-			if hasErr {
-				return
-			}
+		name = sourceName + " (copy)"
+		if runes := []rune(name); len(runes) > 100 {
+			name = string(runes[:100])
+		}
+	}
 
-			services.WebSocketToast(wsId, html.ToastInfo, "Media added successfully", msg)
-		}()
-		Toast(c, html.ToastInfo, "Adding new media", template.HTML(template.HTMLEscapeString(fmt.Sprintf("Adding media with URL %s to playlist...", url))))
+	if !playlistNameRegex.MatchString(name) {
+		handler.PublicError(http.StatusUnprocessableEntity, invalidTitleError)
+		return
+	}
+
+	copyId, hasErr := services.CopyPlaylist(tx, id, username, name)
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	HxRedirect(c, "/watch/"+strconv.Itoa(copyId))
+}
+
+func parsePlaylistRef(ref string) (id int, err error) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return 0, invalidMergeSourceError
+	}
+
+	match := playlistRefRegex.FindStringSubmatch(u.Path)
+	if match == nil {
+		return 0, invalidMergeSourceError
+	}
+
+	if id, err = strconv.Atoi(match[1]); err != nil {
+		return 0, invalidMergeSourceError
+	}
+
+	return id, nil
+}
+
+func playlistMerge(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist merge error")
+	id := stores.GetPlaylistId(c)
+
+	pos, err := services.ParsePlaylistAddPosition(c.PostForm("position"))
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	source, err := parsePlaylistRef(c.PostForm("merge-source"))
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	canView, hasErr := services.CanViewPlaylist(tx, stores.GetUsername(c), source)
+	if hasErr {
+		return
+	}
+
+	if !canView {
+		handler.PublicError(http.StatusNotFound, mergeSourceNotFoundError)
+		return
+	}
+
+	granted, hasErr := services.HasPlaylistAccess(tx, stores.GetUsername(c), source, middlewares.GetPlaylistAccessToken(c, source))
+	if hasErr {
+		return
+	}
+
+	if !granted {
+		handler.PublicError(http.StatusForbidden, services.AccessCodeRequiredError)
+		return
+	}
+
+	var sourceName string
+	if tx.QueryRow("SELECT name FROM playlists WHERE id = $1", source).Scan(nil, &sourceName) {
+		return
+	}
+
+	numItems, hasErr := services.MergePlaylist(tx, id, source, pos)
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	services.WebSocketPlaylistEvent(id, services.PlaylistChanged)
+	Toast(c, html.ToastInfo, "Playlist merged", html.StringAsHTML(fmt.Sprintf("%d item(s) of %s merged into playlist", numItems, sourceName)))
+}
+
+func playlistJobs(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist jobs error")
+	id := stores.GetPlaylistId(c)
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	jobs, hasErr := services.EnumeratePlaylistJobs(tx, id)
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := services.RenderJobs(c.Writer, id, jobs, false); err != nil {
+		handler.RenderError(err)
+	}
+}
+
+func playlistJobCancel(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Job cancel error")
+	id := stores.GetPlaylistId(c)
+
+	jobId, err := strconv.Atoi(c.Param("job-id"))
+	if err != nil {
+		handler.PrivateError(err)
+		handler.PublicError(http.StatusNotFound, invalidJobError)
+		return
+	}
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	hasRow, hasErr := services.CancelJob(tx, id, jobId)
+	if hasErr {
+		return
+	}
+
+	if !hasRow {
+		handler.PublicError(http.StatusNotFound, services.JobNotFoundError)
+		return
+	}
+
+	if services.WebSocketJobsChanged(tx, id) || tx.Commit() {
+		return
 	}
+
+	services.StopRunningJob(jobId)
+	Toast(c, html.ToastInfo, "Job cancelled", "No media from this job will be added to the playlist.")
+}
+
+func playlistSearchMedia(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Media search error")
+	id := stores.GetPlaylistId(c)
+
+	query := strings.TrimSpace(c.Query("query"))
+	if query == "" {
+		handler.PublicError(http.StatusUnprocessableEntity, emptySearchQueryError)
+		return
+	}
+
+	results, err := media.Search(c.Request.Context(), media.MediaKind(c.Query("platform")), query, mediaSearchLimit)
+	if errors.Is(err, media.ErrUnsupportedOperation) {
+		handler.PublicError(http.StatusUnprocessableEntity, unsupportedSearchError)
+		return
+	} else if err != nil {
+		// errors of yt-dlp may leak details of the server
+		handler.PrivateError(err)
+		handler.PublicError(http.StatusUnprocessableEntity, searchFailedError)
+		return
+	}
+
+	// thumbnails go through the proxy, so that viewers do not hit the CDNs
+	for i := range results {
+		results[i].Thumbnail = services.ProxiedThumbnailURL(results[i].Thumbnail)
+	}
+
+	html.RenderGin(playlistWatchTmpl, c, "search-results", gin.H{
+		"Id":      id,
+		"Query":   query,
+		"Results": results,
+	})
 }
 
 func playlistGoto(c *gin.Context) {
@@ -763,15 +1501,21 @@ func playlistSubmitMetadata(c *gin.Context) {
 	title := c.PostForm("media-title")
 	artist := c.PostForm("media-artist")
 
+	trim, err := parseTrimForm(c)
+	if err != nil {
+		handler.PublicError(http.StatusBadRequest, err)
+		return
+	}
+
 	tx := db.BeginTx(handler)
 	if tx == nil {
 		return
 	}
 	defer tx.Rollback()
 
-	var media int
+	var itemId, mediaId int
 	var hasRow bool
-	if tx.QueryRow("SELECT i.media FROM playlists p JOIN playlist_items i ON p.current = i.id WHERE p.id = $1", id).Scan(&hasRow, &media) {
+	if tx.QueryRow("SELECT i.id, i.media FROM playlists p JOIN playlist_items i ON p.current = i.id WHERE p.id = $1", id).Scan(&hasRow, &itemId, &mediaId) {
 		return
 	}
 
@@ -780,18 +1524,54 @@ func playlistSubmitMetadata(c *gin.Context) {
 		return
 	}
 
-	if services.SetMediaAltMetadata(tx, title, artist, id, media) {
+	if services.SetMediaAltMetadata(tx, sql.NullString{String: title, Valid: true}, sql.NullString{String: artist, Valid: true}, id, mediaId) {
 		return
 	}
 
+	oldTrim, hasErr := services.GetPlaylistItemTrim(tx, itemId)
+	if hasErr {
+		return
+	}
+
+	// players only restart if the current version changes
+	callback := func() {}
+	if trim != oldTrim {
+		if services.SetPlaylistItemTrim(tx, itemId, trim) {
+			return
+		}
+
+		if services.SetCurrentMedia(tx, id, sql.NullInt32{Int32: int32(itemId), Valid: true}) {
+			return
+		}
+
+		if callback, hasErr = services.NotifyMediaChanged(tx, id, ""); hasErr {
+			return
+		}
+	}
+
 	if tx.Commit() {
 		return
 	}
 
+	callback()
 	services.WebSocketPlaylistEvent(id, services.PlaylistChanged)
 	Toast(c, html.ToastInfo, "Metadata updated", "Metadata of current playlist item was updated successfully")
 }
 
+func parseTrimForm(c *gin.Context) (media.Trim, error) {
+	start, err := media.ParseTrimOffset(c.PostForm("media-start"))
+	if err != nil {
+		return media.Trim{}, err
+	}
+
+	end, err := media.ParseTrimOffset(c.PostForm("media-end"))
+	if err != nil {
+		return media.Trim{}, err
+	}
+
+	return media.NewTrim(start, end)
+}
+
 func playlistNextRequest(c *gin.Context) {
 	handler := errs.NewGinErrorHandler(c, "Playlist next request error")
 	quiet := c.PostForm("quiet") == "true"
@@ -939,10 +1719,16 @@ func playlistManagerDelete(c *gin.Context) {
 		}
 	}
 
+	disconnect, hasErr := services.DisconnectPlaylistViewers(tx, id)
+	if hasErr {
+		return
+	}
+
 	if tx.Commit() {
 		return
 	}
 
+	disconnect()
 	services.WebSocketPlaylistEvent(id, services.ManagersChanged)
 	Toast(c, html.ToastInfo, "Managers successfully removed", template.HTML(template.HTMLEscapeString(fmt.Sprintf("%d manager(s) are removed from playlist", numAffected))))
 }
@@ -1002,3 +1788,45 @@ func playlistMoveDown(c *gin.Context) {
 	services.WebSocketPlaylistEvent(id, services.PlaylistChanged)
 	Toast(c, html.ToastInfo, "Playlist items reordered", template.HTML(template.HTMLEscapeString(fmt.Sprintf("%d item(s) affected", numAffected))))
 }
+
+func playlistExport(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Playlist export error")
+	id := stores.GetPlaylistId(c)
+
+	format, err := services.ParseExportFormat(c.Query("format"))
+	if err != nil {
+		handler.PublicError(http.StatusBadRequest, err)
+		return
+	}
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	info, hasErr := services.GetExportedPlaylistInfo(tx, id)
+	if hasErr || tx.Commit() {
+		return
+	}
+
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("%s.%s", info.Name, format),
+	}))
+	c.Status(http.StatusOK)
+
+	// the response is already being sent, so errors past this point are only
+	// logged and leave a truncated export
+	tx = db.BeginTx(errs.NewLogErrorHandler("Exporting playlist", func(error) error { return nil }))
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	if services.ExportPlaylist(tx, id, info, format, c.Writer) {
+		return
+	}
+
+	tx.Commit()
+}
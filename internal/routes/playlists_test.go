@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/btmxh/plst4/internal/errs"
+	"github.com/btmxh/plst4/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+func newBatchContext(t *testing.T, urls, file string) *gin.Context {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("urls", urls)
+	if file != "" {
+		part, err := form.CreateFormFile("urls-file", "urls.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(file))
+	}
+	form.Close()
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", &body)
+	c.Request.Header.Set("Content-Type", form.FormDataContentType())
+	return c
+}
+
+func TestGetBatchInputs(t *testing.T) {
+	urls := "https://youtu.be/dQw4w9WgXcQ\n\n# comment\n  https://soundcloud.com/artist/track  \n"
+	file := "# exported\nhttps://youtu.be/9bZkp7q19f0\n"
+	c := newBatchContext(t, urls, file)
+
+	inputs, hasErr := getBatchInputs(c, errs.NewGinErrorHandler(c, "test"))
+	if hasErr {
+		t.Fatal(c.Errors)
+	}
+
+	expected := []services.JobInput{
+		{URL: "https://youtu.be/dQw4w9WgXcQ", Line: 1},
+		{URL: "https://soundcloud.com/artist/track", Line: 4},
+		{URL: "https://youtu.be/9bZkp7q19f0", Line: 2},
+	}
+	if !slices.Equal(inputs, expected) {
+		t.Errorf("expected %v, got %v", expected, inputs)
+	}
+}
+
+func TestGetBatchInputsLargeFile(t *testing.T) {
+	file := strings.Repeat("https://youtu.be/dQw4w9WgXcQ\n", maxBatchFileSize/20)
+	c := newBatchContext(t, "", file)
+
+	if _, hasErr := getBatchInputs(c, errs.NewGinErrorHandler(c, "test")); !hasErr {
+		t.Fatal("expected large files to be rejected")
+	}
+
+	if c.Writer.Status() != http.StatusRequestEntityTooLarge || !errors.Is(c.Errors.Last(), batchFileTooLargeError) {
+		t.Errorf("expected %v, got %d %v", batchFileTooLargeError, c.Writer.Status(), c.Errors)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/btmxh/plst4/internal/html"
 	"github.com/btmxh/plst4/internal/middlewares"
@@ -27,6 +28,18 @@ func CreateMainRouter() http.Handler {
 		gzipMode = 0
 	}
 
+	// client IPs (used to throttle access code attempts) are only taken from
+	// X-Forwarded-For when set by a trusted reverse proxy
+	var trustedProxies []string
+	if proxiesStr := os.Getenv("TRUSTED_PROXIES"); proxiesStr != "" {
+		trustedProxies = strings.Split(proxiesStr, ",")
+	}
+
+	if err = router.SetTrustedProxies(trustedProxies); err != nil {
+		slog.Warn("Invalid value for TRUSTED_PROXIES environment variable", "err", err)
+		router.SetTrustedProxies(nil)
+	}
+
 	router.Use(gzip.Gzip(gzipMode))
 	router.Use(middlewares.AuthMiddleware())
 
@@ -39,6 +52,7 @@ func CreateMainRouter() http.Handler {
 	// only enabled when using memorymail
 	MailRouter(router.Group("/mail"))
 	MediasRouter(router.Group("/medias"))
+	ThumbnailsRouter(router.Group("/thumbnails"))
 
 	router.Static("/scripts", "./dist/scripts")
 	router.Static("/styles", "./dist/styles")
@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/errs"
+	"github.com/btmxh/plst4/internal/media"
+	"github.com/btmxh/plst4/internal/middlewares"
+	"github.com/btmxh/plst4/internal/services"
+	"github.com/btmxh/plst4/internal/stores"
+	"github.com/gin-gonic/gin"
+)
+
+const thumbnailMaxAge = 24 * time.Hour
+
+func ThumbnailsRouter(g *gin.RouterGroup) {
+	g.GET("/:id", middlewares.MediaIdMiddleware(), mediaThumbnail)
+	g.GET("/proxy", proxiedThumbnail)
+}
+
+func serveThumbnail(c *gin.Context, path string) {
+	// cached files are named after the upstream URL, so the name identifies
+	// the content
+	c.Header("ETag", fmt.Sprintf("%q", filepath.Base(path)))
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(thumbnailMaxAge.Seconds())))
+	c.File(path)
+}
+
+// Thumbnails of medias not stored yet, e.g. search results.
+func proxiedThumbnail(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Thumbnail error")
+	path, err := services.GetProxiedThumbnail(c.Request.Context(), c.Query("url"), c.Query("sig"))
+	if err != nil {
+		handler.PrivateError(err)
+		c.Redirect(http.StatusFound, media.DefaultThumbnail)
+		return
+	}
+
+	serveThumbnail(c, path)
+}
+
+func mediaThumbnail(c *gin.Context) {
+	handler := errs.NewGinErrorHandler(c, "Thumbnail error")
+	id := stores.GetMediaId(c)
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	thumbnail, _, hasErr := services.GetMediaThumbnail(tx, id)
+	if hasErr {
+		return
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	// local thumbnails (e.g. the placeholder) need no proxying
+	if !thumbnail.Valid || !strings.HasPrefix(thumbnail.String, "http") {
+		c.Redirect(http.StatusFound, media.DefaultThumbnail)
+		return
+	}
+
+	path, err := services.GetCachedThumbnail(c.Request.Context(), id, thumbnail.String)
+	if err != nil {
+		handler.PrivateError(err)
+		c.Redirect(http.StatusFound, media.DefaultThumbnail)
+		return
+	}
+
+	serveThumbnail(c, path)
+}
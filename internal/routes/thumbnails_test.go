@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/btmxh/plst4/internal/media"
+	"github.com/btmxh/plst4/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+func TestProxiedThumbnail(t *testing.T) {
+	var body bytes.Buffer
+	if err := png.Encode(&body, image.NewRGBA(image.Rect(0, 0, 640, 360))); err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body.Bytes())
+	}))
+	defer upstream.Close()
+
+	if err := services.InitThumbnailCache(t.TempDir(), services.DefaultThumbnailCacheSize); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ThumbnailsRouter(router.Group("/thumbnails"))
+
+	proxied := services.ProxiedThumbnailURL(upstream.URL + "/thumbnail.png")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, proxied, nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200 from %s, got %d", proxied, res.Code)
+	}
+
+	if res.Header().Get("ETag") == "" || res.Header().Get("Cache-Control") == "" {
+		t.Errorf("expected caching headers, got %v", res.Header())
+	}
+
+	if _, err := jpeg.Decode(res.Body); err != nil {
+		t.Errorf("expected a JPEG thumbnail: %v", err)
+	}
+
+	// unsigned URLs are not fetched
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/thumbnails/proxy?url="+upstream.URL+"/other.png&sig=forged", nil))
+	if res.Code != http.StatusFound || res.Header().Get("Location") != media.DefaultThumbnail {
+		t.Errorf("expected redirect to the default thumbnail, got %d %v", res.Code, res.Header())
+	}
+}
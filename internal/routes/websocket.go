@@ -1,11 +1,14 @@
 package routes
 
 import (
+	"encoding/json"
 	"log/slog"
 	"net/http"
 	"strconv"
 
 	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/errs"
+	"github.com/btmxh/plst4/internal/middlewares"
 	"github.com/btmxh/plst4/internal/services"
 	"github.com/btmxh/plst4/internal/stores"
 	"github.com/gin-gonic/gin"
@@ -21,6 +24,11 @@ func WebSocketRouter(g *gin.RouterGroup) {
 		}
 
 		username := stores.GetUsername(c)
+		if !canWatchPlaylist(playlist, username, middlewares.GetPlaylistAccessToken(c, playlist)) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
 		websocket.Handler(func(conn *websocket.Conn) {
 			defer conn.Close()
 
@@ -46,15 +54,58 @@ func WebSocketRouter(g *gin.RouterGroup) {
 			callback()
 
 			for {
-				var msg services.WebSocketMsg
-				err = websocket.JSON.Receive(conn, msg)
+				var msg services.WebSocketClientMsg
+				err = websocket.JSON.Receive(conn, &msg)
 				if err != nil {
 					slog.Info("WebSocket connection closed or error", "err", err)
 					break
 				}
 
 				slog.Debug("Received from WebSocket", "id", playlist, "msg", msg)
+				handleWebSocketMsg(handler, playlist, username, msg)
 			}
 		}).ServeHTTP(c.Writer, c.Request)
 	})
 }
+
+func canWatchPlaylist(playlist int, username, accessToken string) bool {
+	tx := db.BeginTx(errs.NewLogErrorHandler("Checking playlist access", func(error) error { return nil }))
+	if tx == nil {
+		return false
+	}
+	defer tx.Rollback()
+
+	canView, hasErr := services.CanViewPlaylist(tx, username, playlist)
+	if hasErr || !canView {
+		return false
+	}
+
+	granted, hasErr := services.HasPlaylistAccess(tx, username, playlist, accessToken)
+	return granted && !hasErr && !tx.Commit()
+}
+
+func handleWebSocketMsg(handler errs.ErrorHandler, playlist int, username string, msg services.WebSocketClientMsg) {
+	switch msg.Type {
+	case services.PlaybackError:
+		var payload services.PlaybackErrorPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			slog.Info("Invalid playback error payload", "id", playlist, "err", err)
+			return
+		}
+
+		tx := db.BeginTx(handler)
+		if tx == nil {
+			return
+		}
+		defer tx.Rollback()
+
+		callback, hasErr := services.ReportPlaybackError(tx, handler, playlist, username, payload)
+		if hasErr || tx.Commit() {
+			return
+		}
+
+		callback()
+	default:
+		slog.Info("Unknown WebSocket message type", "id", playlist, "type", msg.Type)
+	}
+}
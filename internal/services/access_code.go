@@ -0,0 +1,177 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/btmxh/plst4/internal/auth"
+	"github.com/btmxh/plst4/internal/db"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PlaylistAccessTimeout = 30 * 24 * time.Hour
+
+	// wrong access codes allowed per client and playlist in a window
+	maxAccessCodeAttempts   = 5
+	accessCodeAttemptWindow = 15 * time.Minute
+)
+
+var AccessCodeRequiredError = errors.New("This playlist requires an access code.")
+var WrongAccessCodeError = errors.New("Wrong access code.")
+var InvalidAccessCodeError = errors.New("Access codes must be 6 to 64 characters long.")
+var TooManyAccessCodeAttemptsError = errors.New("Too many wrong access codes, try again later.")
+
+var accessCodeRegex = regexp.MustCompile(`^.{6,64}$`)
+
+type accessCodeAttempts struct {
+	count int
+	since time.Time
+}
+
+// Wrong access codes entered recently, keyed by client IP and playlist, so
+// that codes cannot be brute-forced.
+type accessCodeLimiter struct {
+	attempts map[string]*accessCodeAttempts
+	mutex    sync.Mutex
+}
+
+var accessCodeAttemptLimiter = accessCodeLimiter{attempts: make(map[string]*accessCodeAttempts)}
+
+func accessCodeAttemptKey(clientIP string, playlist int) string {
+	return clientIP + "/" + strconv.Itoa(playlist)
+}
+
+func (l *accessCodeLimiter) allowed(key string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	attempts, ok := l.attempts[key]
+	return !ok || now.Sub(attempts.since) >= accessCodeAttemptWindow || attempts.count < maxAccessCodeAttempts
+}
+
+func (l *accessCodeLimiter) fail(key string, now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for key, attempts := range l.attempts {
+		if now.Sub(attempts.since) >= accessCodeAttemptWindow {
+			delete(l.attempts, key)
+		}
+	}
+
+	if attempts, ok := l.attempts[key]; ok {
+		attempts.count++
+	} else {
+		l.attempts[key] = &accessCodeAttempts{count: 1, since: now}
+	}
+}
+
+func (l *accessCodeLimiter) reset(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.attempts, key)
+}
+
+// Whether a visitor with the given access token (possibly empty) may view
+// playlist. Owners and managers never need the access code.
+func HasPlaylistAccess(tx *db.Tx, username string, playlist int, token string) (granted, hasErr bool) {
+	var codeHash sql.NullString
+	var version int
+	if tx.QueryRow("SELECT access_code_hash, access_code_version FROM playlists WHERE id = $1", playlist).Scan(nil, &codeHash, &version) {
+		return false, true
+	}
+
+	if !codeHash.Valid {
+		return true, false
+	}
+
+	if tokenVersion, ok := auth.ParsePlaylistAccess(token, playlist); ok && tokenVersion == version {
+		return true, false
+	}
+
+	return IsPlaylistManager(tx, username, playlist)
+}
+
+func HasPlaylistAccessCode(tx *db.Tx, playlist int) (hasCode, hasErr bool) {
+	hasErr = tx.QueryRow("SELECT access_code_hash IS NOT NULL FROM playlists WHERE id = $1", playlist).Scan(nil, &hasCode)
+	return hasCode, hasErr
+}
+
+// Check code, entered by the client at clientIP, against the access code of
+// playlist, returning a token granting access to it.
+func EnterPlaylistAccessCode(tx *db.Tx, playlist int, code, clientIP string) (signedToken string, hasErr bool) {
+	key := accessCodeAttemptKey(clientIP, playlist)
+	if !accessCodeAttemptLimiter.allowed(key, time.Now()) {
+		tx.PublicError(http.StatusTooManyRequests, TooManyAccessCodeAttemptsError)
+		return "", true
+	}
+
+	var codeHash sql.NullString
+	var version int
+	if tx.QueryRow("SELECT access_code_hash, access_code_version FROM playlists WHERE id = $1", playlist).Scan(nil, &codeHash, &version) {
+		return "", true
+	}
+
+	if codeHash.Valid {
+		if err := bcrypt.CompareHashAndPassword([]byte(codeHash.String), []byte(code)); err != nil {
+			if err == bcrypt.ErrMismatchedHashAndPassword {
+				accessCodeAttemptLimiter.fail(key, time.Now())
+				tx.PublicError(http.StatusForbidden, WrongAccessCodeError)
+			} else {
+				tx.PrivateError(err)
+				tx.PublicError(http.StatusInternalServerError, db.GenericError)
+			}
+			return "", true
+		}
+
+		accessCodeAttemptLimiter.reset(key)
+	}
+
+	signedToken, err := auth.AuthorizePlaylistAccess(playlist, version, PlaylistAccessTimeout)
+	if err != nil {
+		tx.PrivateError(err)
+		tx.PublicError(http.StatusInternalServerError, db.GenericError)
+		return "", true
+	}
+
+	return signedToken, false
+}
+
+// Set the access code of playlist, removing it if code is empty. Tokens issued
+// for the previous code stop working, and the callback disconnects viewers who
+// entered it.
+func SetPlaylistAccessCode(tx *db.Tx, playlist int, code string) (callback func(), hasErr bool) {
+	var codeHash sql.NullString
+	if code != "" {
+		if !accessCodeRegex.MatchString(code) {
+			tx.PublicError(http.StatusUnprocessableEntity, InvalidAccessCodeError)
+			return nil, true
+		}
+
+		hashed, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			tx.PrivateError(err)
+			tx.PublicError(http.StatusInternalServerError, db.GenericError)
+			return nil, true
+		}
+
+		codeHash = sql.NullString{String: string(hashed), Valid: true}
+	}
+
+	if tx.Exec(nil, "UPDATE playlists SET access_code_hash = $1, access_code_version = access_code_version + 1 WHERE id = $2", codeHash, playlist) {
+		return nil, true
+	}
+
+	if !codeHash.Valid {
+		return func() {}, false
+	}
+
+	return disconnectNonManagers(tx, playlist)
+}
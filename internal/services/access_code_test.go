@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessCodeLimiter(t *testing.T) {
+	l := accessCodeLimiter{attempts: make(map[string]*accessCodeAttempts)}
+	key := accessCodeAttemptKey("192.0.2.1", 1)
+	now := time.Now()
+
+	for i := range maxAccessCodeAttempts {
+		if !l.allowed(key, now) {
+			t.Fatalf("expected attempt %d to be allowed", i+1)
+		}
+		l.fail(key, now)
+	}
+
+	if l.allowed(key, now) {
+		t.Fatal("expected attempts to be throttled")
+	}
+
+	// other clients and playlists are not affected
+	if !l.allowed(accessCodeAttemptKey("192.0.2.2", 1), now) || !l.allowed(accessCodeAttemptKey("192.0.2.1", 2), now) {
+		t.Error("expected throttling to be per client and playlist")
+	}
+
+	later := now.Add(accessCodeAttemptWindow)
+	if !l.allowed(key, later) {
+		t.Error("expected attempts to be allowed again after the window")
+	}
+
+	// failures of other clients sweep expired entries
+	l.fail(accessCodeAttemptKey("192.0.2.2", 1), later)
+	if _, ok := l.attempts[key]; ok {
+		t.Error("expected expired attempts to be removed")
+	}
+
+	l.fail(key, later)
+	l.reset(key)
+	if _, ok := l.attempts[key]; ok {
+		t.Error("expected a correct code to reset attempts")
+	}
+}
+
+func TestAccessCodeRegex(t *testing.T) {
+	for code, valid := range map[string]bool{
+		"12345":                  false,
+		"123456":                 true,
+		"mot de passe":           true,
+		string(make([]byte, 65)): false,
+	} {
+		if accessCodeRegex.MatchString(code) != valid {
+			t.Errorf("expected validity of %q to be %v", code, valid)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/errs"
+)
+
+const (
+	DefaultMediaCheckInterval = 10 * time.Minute
+	DefaultMediaRecheckAge    = 24 * time.Hour
+
+	// number of medias checked every interval
+	mediaCheckBatchSize = 20
+)
+
+// Periodically re-resolve medias in playlists that were not checked for
+// recheckAge, updating their availability. A non-positive interval disables
+// the checker.
+func StartMediaChecker(interval, recheckAge time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			checkStaleMedias(recheckAge)
+		}
+	}()
+}
+
+func getStaleMedias(handler errs.ErrorHandler, recheckAge time.Duration) (ids []int, hasErr bool) {
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return nil, true
+	}
+	defer tx.Rollback()
+
+	var rows *sql.Rows
+	if tx.Query(&rows, `
+		SELECT m.id
+		FROM medias m
+		WHERE (m.last_checked IS NULL OR m.last_checked < NOW() - $1 * INTERVAL '1 second')
+			AND EXISTS (SELECT 1 FROM playlist_items i WHERE i.media = m.id)
+		ORDER BY m.last_checked NULLS FIRST
+		LIMIT $2`, int(recheckAge.Seconds()), mediaCheckBatchSize) {
+		return nil, true
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			tx.PrivateError(err)
+			return nil, true
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, false
+}
+
+func checkStaleMedias(recheckAge time.Duration) {
+	handler := errs.NewLogErrorHandler("Checking media availability", func(error) error { return nil })
+	ids, hasErr := getStaleMedias(handler, recheckAge)
+	if hasErr {
+		return
+	}
+
+	for _, id := range ids {
+		checkMediaAvailability(handler, id)
+	}
+}
+
+func checkMediaAvailability(handler errs.ErrorHandler, id int) {
+	check, hasRow, hasErr := ResolveMediaCheck(context.Background(), handler, id)
+	if hasErr || !hasRow {
+		return
+	}
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	result, hasErr := CheckMedia(tx, check)
+	if hasErr {
+		return
+	}
+
+	if result.ResolveErr != nil {
+		slog.Info("Unable to resolve media while checking availability", "id", id, "err", result.ResolveErr)
+	}
+
+	callback := func() {}
+	if result.StatusChanged {
+		callback, hasErr = NotifyMediaPlaylists(tx, result.Id)
+		if hasErr {
+			return
+		}
+	}
+
+	if tx.Commit() {
+		return
+	}
+
+	callback()
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/media"
+	"github.com/dchest/uniuri"
+)
+
+const (
+	DefaultCoverDir = "covers"
+	MaxCoverSize    = 8 << 20
+
+	coverWidth = 640
+)
+
+var InvalidCoverError = errors.New("Unable to read cover image, expected a JPEG, PNG, GIF or WebP image.")
+var CoverMediaNotInPlaylistError = errors.New("Cover media is not in this playlist.")
+
+var coverDir = DefaultCoverDir
+
+func InitCoverDir(dir string) error {
+	coverDir = dir
+	return os.MkdirAll(dir, 0755)
+}
+
+func CoverPath(name string) string {
+	return filepath.Join(coverDir, filepath.Base(name))
+}
+
+// Get the URL of the image shown for a playlist: its uploaded cover, the
+// thumbnail of its cover media or that of its current media, in that order.
+func PlaylistCoverURL(playlist int, coverFile sql.NullString, coverMedia, currentMedia sql.NullInt32) string {
+	switch {
+	case coverFile.Valid:
+		// uploaded covers get a new name when replaced, so it versions the URL
+		return fmt.Sprintf("/playlists/%d/cover?v=%s", playlist, url.QueryEscape(coverFile.String))
+	case coverMedia.Valid:
+		return fmt.Sprintf("/thumbnails/%d", coverMedia.Int32)
+	case currentMedia.Valid:
+		return fmt.Sprintf("/thumbnails/%d", currentMedia.Int32)
+	default:
+		return media.DefaultThumbnail
+	}
+}
+
+func GetPlaylistCover(tx *db.Tx, playlist int) (coverFile sql.NullString, coverMedia, currentMedia sql.NullInt32, hasErr bool) {
+	hasErr = tx.QueryRow("SELECT p.cover_file, p.cover_media, i.media FROM playlists p LEFT JOIN playlist_items i ON i.id = p.current WHERE p.id = $1", playlist).Scan(nil, &coverFile, &coverMedia, &currentMedia)
+	return coverFile, coverMedia, currentMedia, hasErr
+}
+
+// Store an uploaded cover of playlist in the cover directory, resized and
+// converted to JPEG, returning its file name.
+func SaveCoverFile(playlist int, r io.Reader) (name string, err error) {
+	img, err := decodeImage(r, MaxCoverSize)
+	if errors.Is(err, ImageTooLargeError) {
+		return "", err
+	} else if err != nil {
+		return "", errors.Join(InvalidCoverError, err)
+	}
+
+	file, err := os.CreateTemp(coverDir, "upload-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err = jpeg.Encode(file, resizeImage(img, coverWidth), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return "", err
+	}
+
+	name = fmt.Sprintf("%d-%s.jpg", playlist, uniuri.New())
+	if err = os.Rename(file.Name(), CoverPath(name)); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+func RemoveCoverFile(name string) {
+	if err := os.Remove(CoverPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Unable to remove cover file", "name", name, "err", err)
+	}
+}
+
+// Replace the cover of playlist by an uploaded file (if coverFile is valid),
+// the thumbnail of a media (if coverMedia is valid) or nothing. The callback
+// removes the previously uploaded cover.
+func setPlaylistCover(tx *db.Tx, playlist int, coverFile sql.NullString, coverMedia sql.NullInt32) (callback func(), hasErr bool) {
+	var oldFile sql.NullString
+	if tx.QueryRow("SELECT cover_file FROM playlists WHERE id = $1", playlist).Scan(nil, &oldFile) {
+		return nil, true
+	}
+
+	if tx.Exec(nil, "UPDATE playlists SET cover_file = $1, cover_media = $2 WHERE id = $3", coverFile, coverMedia, playlist) {
+		return nil, true
+	}
+
+	return func() {
+		if oldFile.Valid && oldFile != coverFile {
+			RemoveCoverFile(oldFile.String)
+		}
+	}, false
+}
+
+func SetPlaylistCoverFile(tx *db.Tx, playlist int, name string) (callback func(), hasErr bool) {
+	return setPlaylistCover(tx, playlist, sql.NullString{String: name, Valid: true}, sql.NullInt32{})
+}
+
+// Use the thumbnail of a media of playlist as its cover.
+func SetPlaylistCoverMedia(tx *db.Tx, playlist int, mediaId int) (callback func(), hasErr bool) {
+	var dummy int
+	var hasRow bool
+	if tx.QueryRow("SELECT 1 FROM playlist_items WHERE playlist = $1 AND media = $2 LIMIT 1", playlist, mediaId).Scan(&hasRow, &dummy) {
+		return nil, true
+	}
+
+	if !hasRow {
+		tx.PublicError(http.StatusNotFound, CoverMediaNotInPlaylistError)
+		return nil, true
+	}
+
+	return setPlaylistCover(tx, playlist, sql.NullString{}, sql.NullInt32{Int32: int32(mediaId), Valid: true})
+}
+
+func RemovePlaylistCover(tx *db.Tx, playlist int) (callback func(), hasErr bool) {
+	return setPlaylistCover(tx, playlist, sql.NullString{}, sql.NullInt32{})
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"os"
+	"testing"
+)
+
+// A PNG whose header declares width x height pixels, without any pixel data.
+func pngHeader(width, height uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+
+	chunk := make([]byte, 0, 17)
+	chunk = append(chunk, "IHDR"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, width)
+	chunk = binary.BigEndian.AppendUint32(chunk, height)
+	chunk = append(chunk, 8, 6, 0, 0, 0)
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(chunk)-4))
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestSaveCoverFile(t *testing.T) {
+	coverDir = t.TempDir()
+	defer func() { coverDir = DefaultCoverDir }()
+
+	name, err := SaveCoverFile(1, bytes.NewReader(testPNG(t, 1280, 720)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(CoverPath(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		t.Fatalf("unable to read saved cover: %v", err)
+	}
+
+	if config.Width != coverWidth || config.Height != coverWidth*720/1280 {
+		t.Errorf("expected cover to be resized, got %dx%d", config.Width, config.Height)
+	}
+
+	if _, err := SaveCoverFile(1, bytes.NewReader([]byte("not an image"))); !errors.Is(err, InvalidCoverError) {
+		t.Errorf("expected InvalidCoverError, got %v", err)
+	}
+
+	if _, err := SaveCoverFile(1, bytes.NewReader(pngHeader(100_000, 100_000))); !errors.Is(err, ImageTooLargeError) {
+		t.Errorf("expected ImageTooLargeError, got %v", err)
+	}
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btmxh/plst4/internal/db"
+)
+
+type ExportFormat string
+
+const (
+	ExportJSON ExportFormat = "json"
+	ExportM3U8 ExportFormat = "m3u8"
+	ExportXSPF ExportFormat = "xspf"
+	ExportCSV  ExportFormat = "csv"
+
+	// identifies plst4 JSON exports, bumped on incompatible schema changes
+	ExportSchemaName    = "plst4"
+	ExportSchemaVersion = 1
+)
+
+var InvalidExportFormatError = errors.New("Invalid export format, expected one of json, m3u8, xspf or csv.")
+
+func ParseExportFormat(value string) (ExportFormat, error) {
+	switch format := ExportFormat(strings.ToLower(value)); format {
+	case ExportJSON, ExportM3U8, ExportXSPF, ExportCSV:
+		return format, nil
+	case "":
+		return ExportJSON, nil
+	default:
+		return "", InvalidExportFormatError
+	}
+}
+
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportM3U8:
+		return "application/vnd.apple.mpegurl"
+	case ExportXSPF:
+		return "application/xspf+xml"
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// Header of the plst4 JSON schema, the items are streamed separately.
+type ExportedPlaylistInfo struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Name    string    `json:"name"`
+	Owner   string    `json:"owner"`
+	Created time.Time `json:"created"`
+}
+
+type ExportedPlaylist struct {
+	ExportedPlaylistInfo
+	Items []ExportedItem `json:"items"`
+}
+
+type ExportedItem struct {
+	URL       string  `json:"url"`
+	Type      string  `json:"type"`
+	Title     string  `json:"title"`
+	Artist    string  `json:"artist"`
+	AltTitle  *string `json:"alt_title,omitempty"`
+	AltArtist *string `json:"alt_artist,omitempty"`
+	// in seconds
+	Duration int       `json:"duration"`
+	Start    int       `json:"start,omitempty"`
+	End      int       `json:"end,omitempty"`
+	Current  bool      `json:"current,omitempty"`
+	Added    time.Time `json:"added"`
+}
+
+func (item *ExportedItem) DisplayTitle() string {
+	if item.AltTitle != nil {
+		return *item.AltTitle
+	}
+
+	return item.Title
+}
+
+func (item *ExportedItem) DisplayArtist() string {
+	if item.AltArtist != nil {
+		return *item.AltArtist
+	}
+
+	return item.Artist
+}
+
+type playlistExporter interface {
+	begin(info ExportedPlaylistInfo) error
+	item(index int, item ExportedItem) error
+	end() error
+}
+
+func newPlaylistExporter(format ExportFormat, w io.Writer) playlistExporter {
+	switch format {
+	case ExportM3U8:
+		return &m3u8Exporter{w: w}
+	case ExportXSPF:
+		return &xspfExporter{w: w, enc: xml.NewEncoder(w)}
+	case ExportCSV:
+		return &csvExporter{w: csv.NewWriter(w)}
+	default:
+		return &jsonExporter{w: w}
+	}
+}
+
+func GetExportedPlaylistInfo(tx *db.Tx, playlist int) (info ExportedPlaylistInfo, hasErr bool) {
+	info.Format = ExportSchemaName
+	info.Version = ExportSchemaVersion
+	hasErr = tx.QueryRow("SELECT name, owner_username, created_timestamp FROM playlists WHERE id = $1", playlist).Scan(nil, &info.Name, &info.Owner, &info.Created)
+	return info, hasErr
+}
+
+// Write the items of playlist to w in order. Items are written as they are
+// read, so a failure midway leaves w with a truncated export.
+func ExportPlaylist(tx *db.Tx, playlist int, info ExportedPlaylistInfo, format ExportFormat, w io.Writer) (hasErr bool) {
+	var rows *sql.Rows
+	if tx.Query(&rows, `
+		SELECT
+			m.url,
+			m.media_type,
+			m.title,
+			m.artist,
+			a.alt_title,
+			a.alt_artist,
+			m.duration,
+			COALESCE(i.start_offset, 0),
+			COALESCE(i.end_offset, 0),
+			(i.id = p.current) IS TRUE,
+			i.add_timestamp
+		FROM playlist_items i
+		JOIN playlists p ON p.id = i.playlist
+		JOIN medias m ON m.id = i.media
+		LEFT JOIN alt_metadata a ON a.media = m.id AND a.playlist = i.playlist
+		WHERE i.playlist = $1
+		ORDER BY i.item_order`, playlist) {
+		return true
+	}
+	defer rows.Close()
+
+	exporter := newPlaylistExporter(format, w)
+	if err := exporter.begin(info); err != nil {
+		tx.PrivateError(err)
+		return true
+	}
+
+	for index := 0; rows.Next(); index++ {
+		var item ExportedItem
+		var altTitle, altArtist sql.NullString
+		if err := rows.Scan(&item.URL, &item.Type, &item.Title, &item.Artist, &altTitle, &altArtist, &item.Duration, &item.Start, &item.End, &item.Current, &item.Added); err != nil {
+			tx.PrivateError(err)
+			return true
+		}
+
+		if altTitle.Valid {
+			item.AltTitle = &altTitle.String
+		}
+		if altArtist.Valid {
+			item.AltArtist = &altArtist.String
+		}
+
+		if err := exporter.item(index, item); err != nil {
+			tx.PrivateError(err)
+			return true
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		tx.PrivateError(err)
+		return true
+	}
+
+	if err := exporter.end(); err != nil {
+		tx.PrivateError(err)
+		return true
+	}
+
+	return false
+}
+
+type jsonExporter struct {
+	w io.Writer
+}
+
+func (e *jsonExporter) begin(info ExportedPlaylistInfo) error {
+	header, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	// reopen the header object to append the items to it
+	_, err = fmt.Fprintf(e.w, `%s,"items":[`, header[:len(header)-1])
+	return err
+}
+
+func (e *jsonExporter) item(index int, item ExportedItem) error {
+	if index > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonExporter) end() error {
+	_, err := io.WriteString(e.w, "]}\n")
+	return err
+}
+
+type m3u8Exporter struct {
+	w io.Writer
+}
+
+// newlines would break the line-based format
+func m3u8Escape(str string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(str)
+}
+
+func (e *m3u8Exporter) begin(info ExportedPlaylistInfo) error {
+	_, err := fmt.Fprintf(e.w, "#EXTM3U\n#PLAYLIST:%s\n", m3u8Escape(info.Name))
+	return err
+}
+
+func (e *m3u8Exporter) item(index int, item ExportedItem) error {
+	_, err := fmt.Fprintf(e.w, "#EXTINF:%d,%s - %s\n%s\n", item.Duration, m3u8Escape(item.DisplayArtist()), m3u8Escape(item.DisplayTitle()), m3u8Escape(item.URL))
+	return err
+}
+
+func (e *m3u8Exporter) end() error {
+	return nil
+}
+
+type xspfExporter struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+type xspfTrack struct {
+	XMLName  xml.Name `xml:"track"`
+	Location string   `xml:"location"`
+	Title    string   `xml:"title"`
+	Creator  string   `xml:"creator"`
+	// in milliseconds
+	Duration int `xml:"duration"`
+}
+
+func (e *xspfExporter) begin(info ExportedPlaylistInfo) error {
+	if _, err := io.WriteString(e.w, xml.Header+`<playlist version="1" xmlns="http://xspf.org/ns/0/">`); err != nil {
+		return err
+	}
+
+	if err := e.enc.EncodeElement(info.Name, xml.StartElement{Name: xml.Name{Local: "title"}}); err != nil {
+		return err
+	}
+
+	if err := e.enc.EncodeElement(info.Owner, xml.StartElement{Name: xml.Name{Local: "creator"}}); err != nil {
+		return err
+	}
+
+	if err := e.enc.EncodeElement(info.Created.UTC().Format(time.RFC3339), xml.StartElement{Name: xml.Name{Local: "date"}}); err != nil {
+		return err
+	}
+
+	if err := e.enc.Flush(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(e.w, "<trackList>")
+	return err
+}
+
+func (e *xspfExporter) item(index int, item ExportedItem) error {
+	return e.enc.Encode(xspfTrack{
+		Location: item.URL,
+		Title:    item.DisplayTitle(),
+		Creator:  item.DisplayArtist(),
+		Duration: item.Duration * 1000,
+	})
+}
+
+func (e *xspfExporter) end() error {
+	if err := e.enc.Flush(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(e.w, "</trackList></playlist>\n")
+	return err
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) begin(info ExportedPlaylistInfo) error {
+	return e.w.Write([]string{"index", "url", "title", "artist", "original_title", "original_artist", "duration", "start", "end", "added"})
+}
+
+func (e *csvExporter) item(index int, item ExportedItem) error {
+	return e.w.Write([]string{
+		strconv.Itoa(index + 1),
+		item.URL,
+		item.DisplayTitle(),
+		item.DisplayArtist(),
+		item.Title,
+		item.Artist,
+		strconv.Itoa(item.Duration),
+		strconv.Itoa(item.Start),
+		strconv.Itoa(item.End),
+		item.Added.UTC().Format(time.RFC3339),
+	})
+}
+
+func (e *csvExporter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
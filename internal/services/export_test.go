@@ -0,0 +1,134 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/btmxh/plst4/internal/media"
+)
+
+func TestParseExportFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ExportFormat
+		err      error
+	}{
+		{"", ExportJSON, nil},
+		{"json", ExportJSON, nil},
+		{"M3U8", ExportM3U8, nil},
+		{"xspf", ExportXSPF, nil},
+		{"csv", ExportCSV, nil},
+		{"m3u", "", InvalidExportFormatError},
+		{"pdf", "", InvalidExportFormatError},
+	}
+
+	for _, test := range tests {
+		format, err := ParseExportFormat(test.input)
+		if !errors.Is(err, test.err) {
+			t.Errorf("ParseExportFormat(%q): expected error %v, got %v", test.input, test.err, err)
+		} else if format != test.expected {
+			t.Errorf("ParseExportFormat(%q) = %q, expected %q", test.input, format, test.expected)
+		}
+	}
+}
+
+var testExportInfo = ExportedPlaylistInfo{
+	Format:  ExportSchemaName,
+	Version: ExportSchemaVersion,
+	Name:    "Mix\ntape <3",
+	Owner:   "alice",
+	Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+func testExportItems() []ExportedItem {
+	altTitle := "Alt, \"quoted\""
+	return []ExportedItem{
+		{URL: "https://youtu.be/dQw4w9WgXcQ", Type: "yt", Title: "Title", Artist: "Artist", AltTitle: &altTitle, Duration: 212, Start: 10, End: 20, Current: true},
+		{URL: "https://soundcloud.com/artist/track", Type: "sc", Title: "Track", Artist: "Line\nbreak", Duration: 180},
+	}
+}
+
+func exportTestPlaylist(t *testing.T, format ExportFormat) []byte {
+	var buf bytes.Buffer
+	exporter := newPlaylistExporter(format, &buf)
+	if err := exporter.begin(testExportInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, item := range testExportItems() {
+		if err := exporter.item(i, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := exporter.end(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// Exports of every format but CSV can be imported back.
+func TestExportImportRoundTrip(t *testing.T) {
+	urls := []string{"https://youtu.be/dQw4w9WgXcQ", "https://soundcloud.com/artist/track"}
+	for _, test := range []struct {
+		format   ExportFormat
+		fileName string
+		name     string
+	}{
+		{ExportJSON, "export.json", testExportInfo.Name},
+		{ExportM3U8, "export.m3u8", "Mix tape <3"},
+		{ExportXSPF, "export.xspf", testExportInfo.Name},
+	} {
+		playlist, err := ParsePlaylistFile(test.fileName, bytes.NewReader(exportTestPlaylist(t, test.format)))
+		if err != nil {
+			t.Errorf("%s export cannot be imported: %v", test.format, err)
+			continue
+		}
+
+		if playlist.Name != test.name {
+			t.Errorf("%s export: expected name %q, got %q", test.format, test.name, playlist.Name)
+		}
+
+		var importedUrls []string
+		for _, input := range playlist.Inputs {
+			importedUrls = append(importedUrls, input.URL)
+		}
+
+		if !slices.Equal(importedUrls, urls) {
+			t.Errorf("%s export: expected URLs %q, got %q", test.format, urls, importedUrls)
+		}
+
+		// only the JSON schema keeps alt metadata and trims
+		if test.format == ExportJSON {
+			input := playlist.Inputs[0]
+			if input.AltTitle != valid("Alt, \"quoted\"") || input.AltArtist.Valid || input.Trim != (media.Trim{Start: 10 * time.Second, End: 20 * time.Second}) {
+				t.Errorf("JSON export: unexpected first input %+v", input)
+			}
+		}
+	}
+}
+
+func TestCSVExport(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(exportTestPlaylist(t, ExportCSV))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %d records", len(records))
+	}
+
+	expected := []string{"1", "https://youtu.be/dQw4w9WgXcQ", "Alt, \"quoted\"", "Artist", "Title", "Artist", "212", "10", "20", "0001-01-01T00:00:00Z"}
+	if !slices.Equal(records[1], expected) {
+		t.Errorf("unexpected row %q, expected %q", records[1], expected)
+	}
+
+	if records[2][3] != "Line\nbreak" {
+		t.Errorf("expected newlines to be kept in quoted fields, got %q", records[2][3])
+	}
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/media"
+)
+
+const (
+	MaxImportFileSize = 4 << 20
+	MaxImportItems    = 1000
+	// size of title and alt_metadata columns
+	maxAltMetadataSize = 255
+)
+
+var InvalidImportFileError = errors.New("Unable to parse playlist file, expected a plst4 JSON export, a cytube playlist, an M3U or an XSPF playlist.")
+var UnsupportedImportVersionError = errors.New("Playlist file was exported by a newer version of plst4.")
+var EmptyImportError = errors.New("Playlist file contains no media.")
+var ImportTooLargeError = fmt.Errorf("Playlist files can contain at most %d media.", MaxImportItems)
+var ImportFileTooLargeError = fmt.Errorf("Playlist file is too large, the limit is %d MiB.", MaxImportFileSize>>20)
+
+// Playlist read from an imported file. Entries that could not be read are
+// kept as inputs with Error set, so that they show up in the job report.
+type ImportedPlaylist struct {
+	// empty if the file does not name the playlist
+	Name   string
+	Inputs []JobInput
+	// medias whose metadata is known from the file, so that they can be added
+	// without resolving
+	Medias []ImportedMedia
+}
+
+type ImportedMedia struct {
+	Kind      media.MediaKind
+	URL       string
+	Title     string
+	Duration  int
+	Thumbnail string
+}
+
+// Store medias known from an imported file, keeping the existing ones. Their
+// metadata is completed by the next availability check (see CheckMedia).
+func AddImportedMedias(tx *db.Tx, medias []ImportedMedia) (hasErr bool) {
+	for _, m := range medias {
+		if tx.Exec(nil, "INSERT INTO medias (media_type, title, artist, duration, url, thumbnail) VALUES ($1, $2, '', $3, $4, NULLIF($5, '')) ON CONFLICT (url) DO NOTHING", string(m.Kind), m.Title, m.Duration, m.URL, m.Thumbnail) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func importUrlError(desc, value string) JobInput {
+	return JobInput{URL: value, Error: fmt.Sprintf("%s is not a link", desc)}
+}
+
+func isImportableUrl(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func truncateAltMetadata(value *string) sql.NullString {
+	if value == nil {
+		return sql.NullString{}
+	}
+
+	runes := []rune(*value)
+	if len(runes) > maxAltMetadataSize {
+		runes = runes[:maxAltMetadataSize]
+	}
+
+	return sql.NullString{String: string(runes), Valid: true}
+}
+
+// Read a playlist file, picking the format from the file name or, failing
+// that, from the content.
+func ParsePlaylistFile(name string, r io.Reader) (playlist ImportedPlaylist, err error) {
+	// read one more byte to tell files at the limit from larger ones
+	data, err := io.ReadAll(io.LimitReader(r, MaxImportFileSize+1))
+	if err != nil {
+		return playlist, err
+	}
+
+	if len(data) > MaxImportFileSize {
+		return playlist, ImportFileTooLargeError
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	switch ext := strings.ToLower(filepath.Ext(name)); {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		playlist, err = parseCytubePlaylist(data)
+	case ext == ".json" || bytes.HasPrefix(trimmed, []byte("{")):
+		playlist, err = parseJSONPlaylist(data)
+	case ext == ".xspf" || bytes.HasPrefix(trimmed, []byte("<")):
+		playlist, err = parseXSPFPlaylist(data)
+	default:
+		playlist, err = parseM3UPlaylist(data)
+	}
+
+	if err != nil {
+		return playlist, err
+	}
+
+	if len(playlist.Inputs) == 0 {
+		return playlist, EmptyImportError
+	}
+
+	if len(playlist.Inputs) > MaxImportItems {
+		return playlist, ImportTooLargeError
+	}
+
+	return playlist, nil
+}
+
+func parseJSONPlaylist(data []byte) (playlist ImportedPlaylist, err error) {
+	var exported ExportedPlaylist
+	if err = json.Unmarshal(data, &exported); err != nil {
+		return playlist, errors.Join(InvalidImportFileError, err)
+	}
+
+	if exported.Format != ExportSchemaName {
+		return playlist, InvalidImportFileError
+	}
+
+	if exported.Version > ExportSchemaVersion {
+		return playlist, UnsupportedImportVersionError
+	}
+
+	playlist.Name = exported.Name
+	for i, item := range exported.Items {
+		if !isImportableUrl(item.URL) {
+			playlist.Inputs = append(playlist.Inputs, importUrlError(fmt.Sprintf("URL of item %d", i+1), item.URL))
+			continue
+		}
+
+		trim, err := media.NewTrim(time.Duration(item.Start)*time.Second, time.Duration(item.End)*time.Second)
+		if err != nil || item.Start < 0 || item.End < 0 {
+			trim = media.Trim{}
+		}
+
+		playlist.Inputs = append(playlist.Inputs, JobInput{
+			URL:       item.URL,
+			AltTitle:  truncateAltMetadata(item.AltTitle),
+			AltArtist: truncateAltMetadata(item.AltArtist),
+			Trim:      trim,
+		})
+	}
+
+	return playlist, nil
+}
+
+type cytubeMedia struct {
+	Id      string `json:"id"`
+	Title   string `json:"title"`
+	Seconds int    `json:"seconds"`
+	Type    string `json:"type"`
+}
+
+// Entries of channel playlists wrap the media, while user playlists store it
+// directly.
+type cytubeItem struct {
+	Media *cytubeMedia `json:"media"`
+	cytubeMedia
+}
+
+func parseCytubePlaylist(data []byte) (playlist ImportedPlaylist, err error) {
+	var items []cytubeItem
+	if err = json.Unmarshal(data, &items); err != nil {
+		return playlist, errors.Join(InvalidImportFileError, err)
+	}
+
+	for _, item := range items {
+		entry := item.cytubeMedia
+		if item.Media != nil {
+			entry = *item.Media
+		}
+
+		mapped, err := media.MapCytubeMedia(entry.Type, entry.Id)
+		if err != nil {
+			playlist.Inputs = append(playlist.Inputs, JobInput{URL: mapped.URL, Error: err.Error()})
+			continue
+		}
+
+		if mapped.Kind == media.MediaKindYoutube && entry.Title != "" {
+			playlist.Medias = append(playlist.Medias, ImportedMedia{
+				Kind:      mapped.Kind,
+				URL:       mapped.URL,
+				Title:     truncateAltMetadata(&entry.Title).String,
+				Duration:  max(entry.Seconds, 0),
+				Thumbnail: mapped.Thumbnail,
+			})
+		}
+
+		playlist.Inputs = append(playlist.Inputs, JobInput{URL: mapped.URL})
+	}
+
+	return playlist, nil
+}
+
+// Extended M3U directives are ignored, except for the playlist name.
+func parseM3UPlaylist(data []byte) (playlist ImportedPlaylist, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, MaxImportFileSize+1)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if name, ok := strings.CutPrefix(line, "#PLAYLIST:"); ok {
+			playlist.Name = strings.TrimSpace(name)
+			continue
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !isImportableUrl(line) {
+			playlist.Inputs = append(playlist.Inputs, importUrlError(fmt.Sprintf("Line %d", lineNum), line))
+			continue
+		}
+
+		playlist.Inputs = append(playlist.Inputs, JobInput{URL: line})
+	}
+
+	if err = scanner.Err(); err != nil {
+		return playlist, errors.Join(InvalidImportFileError, err)
+	}
+
+	return playlist, nil
+}
+
+type xspfPlaylist struct {
+	Title  string `xml:"title"`
+	Tracks []struct {
+		Locations []string `xml:"location"`
+	} `xml:"trackList>track"`
+}
+
+func parseXSPFPlaylist(data []byte) (playlist ImportedPlaylist, err error) {
+	var parsed xspfPlaylist
+	if err = xml.Unmarshal(data, &parsed); err != nil {
+		return playlist, errors.Join(InvalidImportFileError, err)
+	}
+
+	playlist.Name = strings.TrimSpace(parsed.Title)
+	for i, track := range parsed.Tracks {
+		input := importUrlError(fmt.Sprintf("Location of track %d", i+1), strings.Join(track.Locations, " "))
+		for _, location := range track.Locations {
+			if location = strings.TrimSpace(location); isImportableUrl(location) {
+				input = JobInput{URL: location}
+				break
+			}
+		}
+
+		playlist.Inputs = append(playlist.Inputs, input)
+	}
+
+	return playlist, nil
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btmxh/plst4/internal/media"
+)
+
+func valid(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: true}
+}
+
+func checkImportedInputs(t *testing.T, playlist ImportedPlaylist, expected []JobInput) {
+	t.Helper()
+	if !slices.Equal(playlist.Inputs, expected) {
+		t.Errorf("unexpected inputs %+v, expected %+v", playlist.Inputs, expected)
+	}
+}
+
+func TestParseJSONPlaylist(t *testing.T) {
+	data := `{
+		"format": "plst4", "version": 1, "name": "Exported",
+		"items": [
+			{"url": "https://youtu.be/dQw4w9WgXcQ", "alt_title": "Alt", "alt_artist": "", "start": 10, "end": 20},
+			{"url": "https://youtu.be/dQw4w9WgXcQ", "start": 20, "end": 10},
+			{"url": "javascript:alert(1)"}
+		]
+	}`
+
+	playlist, err := ParsePlaylistFile("export.json", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if playlist.Name != "Exported" {
+		t.Errorf("expected name Exported, got %q", playlist.Name)
+	}
+
+	checkImportedInputs(t, playlist, []JobInput{
+		{URL: "https://youtu.be/dQw4w9WgXcQ", AltTitle: valid("Alt"), AltArtist: valid(""), Trim: media.Trim{Start: 10 * time.Second, End: 20 * time.Second}},
+		// invalid trims are dropped rather than failing the item
+		{URL: "https://youtu.be/dQw4w9WgXcQ"},
+		{URL: "javascript:alert(1)", Error: "URL of item 3 is not a link"},
+	})
+
+	_, err = ParsePlaylistFile("export.json", strings.NewReader(`{"format": "plst4", "version": 2, "items": []}`))
+	if !errors.Is(err, UnsupportedImportVersionError) {
+		t.Errorf("expected UnsupportedImportVersionError, got %v", err)
+	}
+
+	_, err = ParsePlaylistFile("other.json", strings.NewReader(`{"format": "other", "items": []}`))
+	if !errors.Is(err, InvalidImportFileError) {
+		t.Errorf("expected InvalidImportFileError, got %v", err)
+	}
+}
+
+func TestParseCytubePlaylist(t *testing.T) {
+	// user playlists store medias directly, channel playlists wrap them
+	data := `[
+		{"id": "dQw4w9WgXcQ", "title": "Channel title", "seconds": 212, "type": "yt"},
+		{"media": {"id": "https://soundcloud.com/artist/track", "title": "", "type": "sc"}},
+		{"media": {"id": "123456", "title": "Vimeo video", "type": "vi"}}
+	]`
+
+	playlist, err := ParsePlaylistFile("playlist.json", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkImportedInputs(t, playlist, []JobInput{
+		{URL: "https://youtu.be/dQw4w9WgXcQ"},
+		{URL: "https://soundcloud.com/artist/track"},
+		{URL: "https://vimeo.com/123456", Error: "Media type not supported by plst4: Vimeo"},
+	})
+
+	expected := []ImportedMedia{{
+		Kind:      media.MediaKindYoutube,
+		URL:       "https://youtu.be/dQw4w9WgXcQ",
+		Title:     "Channel title",
+		Duration:  212,
+		Thumbnail: "https://i3.ytimg.com/vi/dQw4w9WgXcQ/maxresdefault.jpg",
+	}}
+	if !reflect.DeepEqual(playlist.Medias, expected) {
+		t.Errorf("expected medias %v, got %v", expected, playlist.Medias)
+	}
+}
+
+func TestParseM3UPlaylist(t *testing.T) {
+	data := "#EXTM3U\n#PLAYLIST: Mixtape \n#EXTINF:212,Artist - Title\nhttps://youtu.be/dQw4w9WgXcQ\n\n/home/user/music/song.mp3\r\n  https://soundcloud.com/artist/track  \n"
+	playlist, err := ParsePlaylistFile("mixtape.m3u8", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if playlist.Name != "Mixtape" {
+		t.Errorf("expected name Mixtape, got %q", playlist.Name)
+	}
+
+	checkImportedInputs(t, playlist, []JobInput{
+		{URL: "https://youtu.be/dQw4w9WgXcQ"},
+		{URL: "/home/user/music/song.mp3", Error: "Line 6 is not a link"},
+		{URL: "https://soundcloud.com/artist/track"},
+	})
+}
+
+func TestParseXSPFPlaylist(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+	<title>Mixtape</title>
+	<trackList>
+		<track><location>file:///song.mp3</location><location>https://youtu.be/dQw4w9WgXcQ</location></track>
+		<track><location>file:///other.mp3</location></track>
+	</trackList>
+</playlist>`
+
+	playlist, err := ParsePlaylistFile("mixtape.xspf", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if playlist.Name != "Mixtape" {
+		t.Errorf("expected name Mixtape, got %q", playlist.Name)
+	}
+
+	checkImportedInputs(t, playlist, []JobInput{
+		{URL: "https://youtu.be/dQw4w9WgXcQ"},
+		{URL: "file:///other.mp3", Error: "Location of track 2 is not a link"},
+	})
+}
+
+func TestParsePlaylistFileLimits(t *testing.T) {
+	if _, err := ParsePlaylistFile("empty.m3u", strings.NewReader("#EXTM3U\n")); !errors.Is(err, EmptyImportError) {
+		t.Errorf("expected EmptyImportError, got %v", err)
+	}
+
+	var many strings.Builder
+	for i := range MaxImportItems + 1 {
+		fmt.Fprintf(&many, "https://example.com/%d\n", i)
+	}
+
+	if _, err := ParsePlaylistFile("many.m3u", strings.NewReader(many.String())); !errors.Is(err, ImportTooLargeError) {
+		t.Errorf("expected ImportTooLargeError, got %v", err)
+	}
+
+	// a file cut at the size limit would still parse as M3U
+	large := "https://example.com/a\n" + strings.Repeat("#", MaxImportFileSize)
+	if _, err := ParsePlaylistFile("large.m3u", strings.NewReader(large)); !errors.Is(err, ImportFileTooLargeError) {
+		t.Errorf("expected ImportFileTooLargeError, got %v", err)
+	}
+
+	exact := "https://example.com/a\n" + strings.Repeat("#", MaxImportFileSize-len("https://example.com/a\n"))
+	if _, err := ParsePlaylistFile("exact.m3u", strings.NewReader(exact)); err != nil {
+		t.Errorf("expected file at the size limit to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,415 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/errs"
+	"github.com/btmxh/plst4/internal/html"
+	"github.com/btmxh/plst4/internal/media"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultJobWorkers = 2
+
+	// pending jobs are normally picked up right away, polling only matters
+	// for jobs created by other processes
+	jobPollInterval = 10 * time.Second
+	// minimum interval between two progress reports of the same job
+	jobReportInterval = time.Second
+	// number of failures listed in the summary toast
+	jobFailureSummaryLimit = 10
+)
+
+// Runs jobs in the background, one at a time per worker goroutine.
+type JobRunner struct {
+	wake    chan struct{}
+	running map[int]context.CancelFunc
+	mutex   sync.Mutex
+}
+
+var jobRunner = JobRunner{
+	wake:    make(chan struct{}, 1),
+	running: make(map[int]context.CancelFunc),
+}
+
+func StartJobWorkers(numWorkers int) {
+	tx := db.BeginTx(errs.NewLogErrorHandler("Resuming jobs", func(error) error { return nil }))
+	if tx != nil {
+		defer tx.Rollback()
+		if !ResetRunningJobs(tx) {
+			tx.Commit()
+		}
+	}
+
+	for range numWorkers {
+		go jobRunner.work()
+	}
+}
+
+// Wake up a worker to pick up newly created jobs.
+func NotifyJobCreated() {
+	select {
+	case jobRunner.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop the job if it is currently running. The job status must be updated
+// separately (see CancelJob).
+func StopRunningJob(job int) {
+	jobRunner.mutex.Lock()
+	defer jobRunner.mutex.Unlock()
+
+	if cancel, ok := jobRunner.running[job]; ok {
+		cancel()
+	}
+}
+
+func (r *JobRunner) work() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for r.runNext() {
+		}
+
+		select {
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *JobRunner) runNext() bool {
+	handler := errs.NewLogErrorHandler("Claiming job", func(error) error { return nil })
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return false
+	}
+	defer tx.Rollback()
+
+	job, hasRow, hasErr := claimJob(tx)
+	if hasErr || !hasRow || tx.Commit() {
+		return false
+	}
+
+	// there might be more pending jobs for other workers
+	NotifyJobCreated()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mutex.Lock()
+	r.running[job.Id] = cancel
+	r.mutex.Unlock()
+
+	defer func() {
+		r.mutex.Lock()
+		delete(r.running, job.Id)
+		r.mutex.Unlock()
+		cancel()
+	}()
+
+	slog.Info("Running job", "job", job.Id, "playlist", job.Playlist)
+	runJob(ctx, job)
+	return true
+}
+
+func runJob(ctx context.Context, job Job) {
+	handler := NewWebSocketErrorHandler("Unable to add media to playlist", job.SocketId)
+
+	var lastReport time.Time
+	for {
+		processed, hasErr := processJobItem(ctx, handler, job)
+		switch {
+		case ctx.Err() != nil:
+			// cancelled, the status is already updated by whoever cancelled the
+			// job
+			reportJobProgress(handler, job.Playlist)
+			return
+		case hasErr:
+			failJob(handler, job)
+			return
+		case !processed:
+			finishJob(handler, job)
+			return
+		}
+
+		if time.Since(lastReport) >= jobReportInterval {
+			reportJobProgress(handler, job.Playlist)
+			lastReport = time.Now()
+		}
+	}
+}
+
+// Resolve the next pending item of job. Failing to resolve an item is
+// recorded in the item itself, hasErr is only set if the job cannot continue.
+func processJobItem(ctx context.Context, handler errs.ErrorHandler, job Job) (processed, hasErr bool) {
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return false, true
+	}
+	item, hasRow, hasErr := nextJobItem(tx, job.Id)
+	tx.Rollback()
+	if hasErr || !hasRow {
+		return false, hasErr
+	}
+
+	capture := errs.NewCapturingErrorHandler()
+	mediaId, entryUrls, ok := resolveJobItem(ctx, capture, item)
+	if !ok && ctx.Err() != nil {
+		// the item is retried if the job is ever resumed
+		return false, false
+	}
+
+	tx = db.BeginTx(handler)
+	if tx == nil {
+		return false, true
+	}
+	defer tx.Rollback()
+
+	switch {
+	case !ok:
+		hasErr = setJobItemError(tx, job.Id, item, capturedErrorMessage(capture))
+	case entryUrls != nil:
+		hasErr = expandJobItem(tx, job.Id, item, entryUrls)
+	default:
+		hasErr = setJobItemMedia(tx, job.Id, item, mediaId)
+	}
+
+	if hasErr || tx.Commit() {
+		return false, true
+	}
+
+	return true, false
+}
+
+// Resolve a job item to a media, or to the URLs of the entries of the media
+// list it refers to.
+func resolveJobItem(ctx context.Context, handler errs.ErrorHandler, item jobItem) (mediaId int, entryUrls []string, ok bool) {
+	// canonical URLs (e.g. from exported playlists) of known medias need no
+	// resolving
+	if item.listMode != "list" {
+		mediaId, hasRow, hasErr := findKnownMedia(handler, item.url)
+		if hasErr {
+			return 0, nil, false
+		}
+
+		if hasRow {
+			return mediaId, nil, true
+		}
+	}
+
+	mediaObj, err := media.ProcessURL(item.url)
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
+		return 0, nil, false
+	}
+
+	if withList, isWithList := mediaObj.(media.MediaObjectWithList); isWithList && item.listMode == "list" {
+		mediaObj = withList.MediaList()
+	}
+
+	canonMedia, err := media.Canonicalize(ctx, mediaObj)
+	if err != nil {
+		handler.PublicError(http.StatusUnprocessableEntity, err)
+		return 0, nil, false
+	}
+
+	// list entries are single medias, anything else might be a list
+	if item.listMode != jobItemListEntry {
+		mediaId, hasRow, hasErr := findKnownMedia(handler, canonMedia.URL().String())
+		if hasErr {
+			return 0, nil, false
+		}
+
+		if hasRow {
+			return mediaId, nil, true
+		}
+
+		// entries are kept in the resolve cache, so resolving them later is
+		// cheap
+		resolved, err := media.Resolve(ctx, canonMedia)
+		if err != nil {
+			handler.PublicError(http.StatusUnprocessableEntity, err)
+			return 0, nil, false
+		}
+
+		if _, isSingle := resolved.(media.ResolvedMediaObjectSingle); !isSingle {
+			entryUrls = []string{}
+			for _, entry := range resolved.ChildEntries() {
+				entryUrls = append(entryUrls, entry.URL().String())
+			}
+
+			return 0, entryUrls, true
+		}
+	}
+
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return 0, nil, false
+	}
+	defer tx.Rollback()
+
+	_, mediaIds, hasErr := ResolveMedias(ctx, tx, canonMedia)
+	if hasErr || tx.Commit() {
+		return 0, nil, false
+	}
+
+	if len(mediaIds) != 1 {
+		handler.PublicError(http.StatusUnprocessableEntity, mediaNoLongerSingleError)
+		return 0, nil, false
+	}
+
+	return mediaIds[0], nil, true
+}
+
+func findKnownMedia(handler errs.ErrorHandler, url string) (id int, hasRow, hasErr bool) {
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return 0, false, true
+	}
+	defer tx.Rollback()
+
+	return GetMediaId(tx, url)
+}
+
+func capturedErrorMessage(capture *errs.CaptureErrorHandler) string {
+	for _, err := range capture.Errors {
+		if err.IsType(gin.ErrorTypePublic) {
+			return err.Error()
+		}
+	}
+
+	return GenericError.Error()
+}
+
+func finishJob(handler errs.ErrorHandler, job Job) {
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	status, hasErr := lockJob(tx, job.Id)
+	if hasErr || status != JobRunning {
+		return
+	}
+
+	medias, hasErr := getJobMedias(tx, job.Id)
+	if hasErr {
+		return
+	}
+
+	var mediaIds []int
+	for _, m := range medias {
+		mediaIds = append(mediaIds, m.id)
+	}
+
+	failures, hasErr := GetJobFailures(tx, job.Id)
+	if hasErr {
+		return
+	}
+
+	itemIds, hasErr := InsertPlaylistItems(tx, job.Playlist, mediaIds, job.Position)
+	if hasErr {
+		return
+	}
+
+	for i, m := range medias {
+		if m.trim != (media.Trim{}) && SetPlaylistItemTrim(tx, itemIds[i], m.trim) {
+			return
+		}
+
+		if (m.altTitle.Valid || m.altArtist.Valid) && SetMediaAltMetadata(tx, m.altTitle, m.altArtist, job.Playlist, m.id) {
+			return
+		}
+	}
+
+	if SetJobStatus(tx, job.Id, JobDone) || tx.Commit() {
+		return
+	}
+
+	reportJobProgress(handler, job.Playlist)
+
+	if len(mediaIds) > 0 {
+		WebSocketPlaylistEvent(job.Playlist, PlaylistChanged)
+		jobToast(job, html.ToastInfo, "Media added successfully", html.StringAsHTML(fmt.Sprintf("%d media added to playlist", len(mediaIds))))
+	}
+
+	if len(failures) > 0 {
+		jobToast(job, html.ToastError, "Some media could not be added", summarizeJobFailures(failures))
+	}
+}
+
+// Jobs created without a WebSocket (e.g. importing into a new playlist), or
+// whose WebSocket has disconnected since, report to everyone watching the
+// playlist instead.
+func jobToast(job Job, kind html.ToastKind, title, description template.HTML) {
+	if !GetManager().HasSocket(job.SocketId) {
+		WebSocketPlaylistToast(job.Playlist, kind, title, description)
+	} else {
+		WebSocketToast(job.SocketId, kind, title, description)
+	}
+}
+
+func summarizeJobFailures(failures []JobFailure) template.HTML {
+	var lines []string
+	for i, failure := range failures {
+		if i == jobFailureSummaryLimit {
+			lines = append(lines, fmt.Sprintf("and %d more", len(failures)-i))
+			break
+		}
+
+		// batch adds refer to the lines the URLs were read from
+		label := fmt.Sprintf("%d.", failure.Index+1)
+		if failure.Line > 0 {
+			label = fmt.Sprintf("Line %d:", failure.Line)
+		}
+
+		line := fmt.Sprintf("%s %s: %s", label, failure.URL, failure.Error)
+		if failure.URL == "" {
+			line = fmt.Sprintf("%s %s", label, failure.Error)
+		}
+
+		lines = append(lines, template.HTMLEscapeString(line))
+	}
+
+	return template.HTML(strings.Join(lines, "<br>"))
+}
+
+func failJob(handler errs.ErrorHandler, job Job) {
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	status, hasErr := lockJob(tx, job.Id)
+	if hasErr || status != JobRunning {
+		return
+	}
+
+	if SetJobStatus(tx, job.Id, JobFailed) || tx.Commit() {
+		return
+	}
+
+	reportJobProgress(handler, job.Playlist)
+}
+
+func reportJobProgress(handler errs.ErrorHandler, playlist int) {
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+
+	WebSocketJobsChanged(tx, playlist)
+}
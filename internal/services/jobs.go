@@ -0,0 +1,302 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/html"
+	"github.com/btmxh/plst4/internal/media"
+	"github.com/gin-gonic/gin"
+)
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+var JobNotFoundError = errors.New("No such running job in this playlist.")
+var emptyMediaListError = errors.New("Media list is empty.")
+
+var jobsTmpl = html.GetTemplate("jobs", "templates/playlists/jobs.tmpl")
+
+// A URL to be added by a job. ListMode picks between the single media and the
+// media list for URLs referring to both (see media.MediaObjectWithList).
+type JobInput struct {
+	URL      string
+	ListMode string
+	// alt metadata and trim of the added item, ignored for media lists
+	AltTitle  sql.NullString
+	AltArtist sql.NullString
+	Trim      media.Trim
+	// set for inputs known to be invalid beforehand, which are reported
+	// along with the failures of the job
+	Error string
+	// line of the URL in the batch it was read from, 0 if there is none
+	Line int
+}
+
+type Job struct {
+	Id       int
+	Playlist int
+	Username string
+	SocketId string
+	Position PlaylistAddPosition
+}
+
+type JobProgress struct {
+	Id       int
+	Status   JobStatus
+	Done     int
+	Total    int
+	Failures int
+}
+
+type jobItem struct {
+	index    int
+	subIndex int
+	url      string
+	listMode string
+}
+
+// List mode of the entries of an expanded media list, which are single medias.
+const jobItemListEntry = "entry"
+
+type JobFailure struct {
+	Index int
+	Line  int
+	URL   string
+	Error string
+}
+
+func CreateJob(tx *db.Tx, playlist int, username, socketId string, pos PlaylistAddPosition, inputs []JobInput) (id int, hasErr bool) {
+	if tx.QueryRow("INSERT INTO jobs (playlist, username, socket_id, position) VALUES ($1, $2, NULLIF($3, ''), $4) RETURNING id", playlist, username, socketId, string(pos)).Scan(nil, &id) {
+		return id, true
+	}
+
+	for i, input := range inputs {
+		if tx.Exec(nil, `
+			INSERT INTO job_items (job, item_index, url, list_mode, alt_title, alt_artist, start_offset, end_offset, error, line)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0), NULLIF($8, 0), NULLIF($9, ''), NULLIF($10, 0))`,
+			id, i, input.URL, input.ListMode, input.AltTitle, input.AltArtist, int(input.Trim.Start.Seconds()), int(input.Trim.End.Seconds()), input.Error, input.Line) {
+			return id, true
+		}
+	}
+
+	return id, false
+}
+
+// Atomically take the oldest pending job, marking it as running.
+func claimJob(tx *db.Tx) (job Job, hasRow, hasErr bool) {
+	var socketId sql.NullString
+	hasErr = tx.QueryRow(`
+		UPDATE jobs SET status = 'running', updated_timestamp = NOW()
+		WHERE id = (SELECT id FROM jobs WHERE status = 'pending' ORDER BY id FOR UPDATE SKIP LOCKED LIMIT 1)
+		RETURNING id, playlist, username, socket_id, position`).Scan(&hasRow, &job.Id, &job.Playlist, &job.Username, &socketId, &job.Position)
+	job.SocketId = socketId.String
+	return job, hasRow, hasErr
+}
+
+// Jobs left running by a previous instance of the server are resumed from
+// where they stopped. The sockets that requested unfinished jobs are gone by
+// then, so these jobs report to their playlist instead.
+func ResetRunningJobs(tx *db.Tx) (hasErr bool) {
+	return tx.Exec(nil, "UPDATE jobs SET status = CASE WHEN status = 'running' THEN 'pending' ELSE status END, socket_id = NULL WHERE status IN ('pending', 'running')")
+}
+
+func nextJobItem(tx *db.Tx, job int) (item jobItem, hasRow, hasErr bool) {
+	hasErr = tx.QueryRow("SELECT item_index, sub_index, url, list_mode FROM job_items WHERE job = $1 AND media IS NULL AND error IS NULL ORDER BY item_index, sub_index LIMIT 1", job).Scan(&hasRow, &item.index, &item.subIndex, &item.url, &item.listMode)
+	return item, hasRow, hasErr
+}
+
+func setJobItemMedia(tx *db.Tx, job int, item jobItem, mediaId int) (hasErr bool) {
+	return tx.Exec(nil, "UPDATE job_items SET media = $4 WHERE job = $1 AND item_index = $2 AND sub_index = $3", job, item.index, item.subIndex, mediaId)
+}
+
+// Replace a pending job item referring to a media list by one item per entry
+// of the list, so that they are processed (and reported) one by one.
+func expandJobItem(tx *db.Tx, job int, item jobItem, entryUrls []string) (hasErr bool) {
+	if len(entryUrls) == 0 {
+		return setJobItemError(tx, job, item, emptyMediaListError.Error())
+	}
+
+	if tx.Exec(nil, "UPDATE job_items SET url = $4, list_mode = $5 WHERE job = $1 AND item_index = $2 AND sub_index = $3", job, item.index, item.subIndex, entryUrls[0], jobItemListEntry) {
+		return true
+	}
+
+	for i, url := range entryUrls[1:] {
+		// entries are reported at the line of their media list
+		if tx.Exec(nil, "INSERT INTO job_items (job, item_index, sub_index, url, list_mode, line) SELECT $1, $2, $3, $4, $5, line FROM job_items WHERE job = $1 AND item_index = $2 AND sub_index = 0", job, item.index, i+1, url, jobItemListEntry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setJobItemError(tx *db.Tx, job int, item jobItem, msg string) (hasErr bool) {
+	return tx.Exec(nil, "UPDATE job_items SET error = $4 WHERE job = $1 AND item_index = $2 AND sub_index = $3", job, item.index, item.subIndex, msg)
+}
+
+func SetJobStatus(tx *db.Tx, job int, status JobStatus) (hasErr bool) {
+	return tx.Exec(nil, "UPDATE jobs SET status = $2, updated_timestamp = NOW() WHERE id = $1", job, string(status))
+}
+
+// Lock the job row, so that cancelling and finishing it do not race.
+func lockJob(tx *db.Tx, job int) (status JobStatus, hasErr bool) {
+	hasErr = tx.QueryRow("SELECT status FROM jobs WHERE id = $1 FOR UPDATE", job).Scan(nil, &status)
+	return status, hasErr
+}
+
+func CancelJob(tx *db.Tx, playlist, job int) (hasRow, hasErr bool) {
+	var res sql.Result
+	if tx.Exec(&res, "UPDATE jobs SET status = 'cancelled', updated_timestamp = NOW() WHERE id = $1 AND playlist = $2 AND status IN ('pending', 'running')", job, playlist) {
+		return false, true
+	}
+
+	numAffected, err := res.RowsAffected()
+	if err != nil {
+		tx.PrivateError(err)
+		return false, true
+	}
+
+	return numAffected > 0, false
+}
+
+// A media added by a job, with the metadata to apply to its playlist item.
+type jobMedia struct {
+	id        int
+	trim      media.Trim
+	altTitle  sql.NullString
+	altArtist sql.NullString
+}
+
+// Get the medias added by a job. Single medias are trimmed as given by the
+// job item, or by their URL otherwise.
+func getJobMedias(tx *db.Tx, job int) (medias []jobMedia, hasErr bool) {
+	var rows *sql.Rows
+	if tx.Query(&rows, `
+		SELECT
+			media,
+			url,
+			list_mode IN ('list', 'entry') OR COUNT(*) OVER (PARTITION BY item_index) > 1,
+			start_offset,
+			end_offset,
+			alt_title,
+			alt_artist
+		FROM job_items
+		WHERE job = $1 AND media IS NOT NULL
+		ORDER BY item_index, sub_index`, job) {
+		return nil, true
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m jobMedia
+		var url string
+		var isList bool
+		var start, end sql.NullInt32
+		if err := rows.Scan(&m.id, &url, &isList, &start, &end, &m.altTitle, &m.altArtist); err != nil {
+			tx.PrivateError(err)
+			return nil, true
+		}
+
+		if isList {
+			m.altTitle = sql.NullString{}
+			m.altArtist = sql.NullString{}
+		} else if start.Valid || end.Valid {
+			m.trim = media.Trim{Start: time.Duration(start.Int32) * time.Second, End: time.Duration(end.Int32) * time.Second}
+		} else {
+			m.trim = media.ParseTrim(url)
+		}
+
+		medias = append(medias, m)
+	}
+
+	return medias, false
+}
+
+func GetJobFailures(tx *db.Tx, job int) (failures []JobFailure, hasErr bool) {
+	var rows *sql.Rows
+	if tx.Query(&rows, "SELECT item_index, COALESCE(line, 0), url, error FROM job_items WHERE job = $1 AND error IS NOT NULL ORDER BY item_index", job) {
+		return nil, true
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var failure JobFailure
+		if err := rows.Scan(&failure.Index, &failure.Line, &failure.URL, &failure.Error); err != nil {
+			tx.PrivateError(err)
+			return nil, true
+		}
+
+		failures = append(failures, failure)
+	}
+
+	return failures, false
+}
+
+// Progress of pending and running jobs of a playlist.
+func EnumeratePlaylistJobs(tx *db.Tx, playlist int) (jobs []JobProgress, hasErr bool) {
+	var rows *sql.Rows
+	if tx.Query(&rows, `
+		SELECT
+			j.id,
+			j.status,
+			COUNT(i.media) + COUNT(i.error),
+			COUNT(i.job),
+			COUNT(i.error)
+		FROM jobs j
+		LEFT JOIN job_items i ON i.job = j.id
+		WHERE j.playlist = $1 AND j.status IN ('pending', 'running')
+		GROUP BY j.id
+		ORDER BY j.id`, playlist) {
+		return nil, true
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var job JobProgress
+		if err := rows.Scan(&job.Id, &job.Status, &job.Done, &job.Total, &job.Failures); err != nil {
+			tx.PrivateError(err)
+			return nil, true
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, false
+}
+
+func RenderJobs(w io.Writer, playlist int, jobs []JobProgress, oob bool) error {
+	return jobsTmpl.ExecuteTemplate(w, "jobs", gin.H{
+		"Id":   playlist,
+		"Jobs": jobs,
+		"Oob":  oob,
+	})
+}
+
+// Send the current progress of all jobs of playlist to everyone watching it.
+func WebSocketJobsChanged(tx *db.Tx, playlist int) (hasErr bool) {
+	jobs, hasErr := EnumeratePlaylistJobs(tx, playlist)
+	if hasErr {
+		return true
+	}
+
+	var str strings.Builder
+	if err := RenderJobs(&str, playlist, jobs, true); err != nil {
+		tx.PrivateError(err)
+		return true
+	}
+
+	manager.BroadcastPlaylist(playlist, WebSocketMsg{Type: Swap, Payload: str.String()})
+	return false
+}
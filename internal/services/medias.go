@@ -2,10 +2,14 @@ package services
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"net/http"
 	"net/url"
 	"time"
 
 	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/errs"
 	"github.com/btmxh/plst4/internal/media"
 )
 
@@ -26,6 +30,8 @@ type DatabaseResolvedMediaObject struct {
 	artist      string
 	length      time.Duration
 	aspectRatio string
+	permalink   string
+	thumbnail   string
 }
 
 func (o *DatabaseResolvedMediaObject) Kind() media.MediaKind {
@@ -69,10 +75,21 @@ func (o *DatabaseResolvedMediaObject) AspectRatio() string {
 	return o.aspectRatio
 }
 
+func (o *DatabaseResolvedMediaObject) Permalink() string {
+	return o.permalink
+}
+
+func (o *DatabaseResolvedMediaObject) Thumbnail() string {
+	return o.thumbnail
+}
+
 func GetResolvedMedia(tx *db.Tx, url string) (m media.ResolvedMediaObjectSingle, hasRow, hasErr bool) {
 	var obj DatabaseResolvedMediaObject
+	var permalink, thumbnail sql.NullString
 	obj.url = url
-	hasErr = tx.QueryRow("SELECT media_type, title, artist, duration, aspect_ratio FROM medias WHERE url = $1", url).Scan(&hasRow, &obj.kind, &obj.title, &obj.artist, &obj.length, &obj.aspectRatio)
+	hasErr = tx.QueryRow("SELECT media_type, title, artist, duration, aspect_ratio, permalink, thumbnail FROM medias WHERE url = $1", url).Scan(&hasRow, &obj.kind, &obj.title, &obj.artist, &obj.length, &obj.aspectRatio, &permalink, &thumbnail)
+	obj.permalink = permalink.String
+	obj.thumbnail = thumbnail.String
 	return &obj, hasRow, hasErr
 }
 
@@ -81,26 +98,80 @@ func GetMediaSimpleInfo(tx *db.Tx, url string) (id int, title, artist string, ha
 	return id, title, artist, hasRow, hasErr
 }
 
+func getPermalink(entry media.ResolvedMediaObjectSingle) (permalink sql.NullString) {
+	if p, ok := entry.(media.PermalinkedMediaObject); ok {
+		permalink.String = p.Permalink()
+		permalink.Valid = permalink.String != ""
+	}
+
+	return permalink
+}
+
+func getThumbnail(entry media.ResolvedMediaObjectSingle) (thumbnail sql.NullString) {
+	thumbnail.String = entry.Thumbnail()
+	thumbnail.Valid = thumbnail.String != ""
+	return thumbnail
+}
+
 func AddMedia(tx *db.Tx, entry media.ResolvedMediaObjectSingle) (id int, hasErr bool) {
 	hasErr = tx.QueryRow(`
 		WITH ins AS
-		(INSERT INTO medias (media_type, title, artist, duration, url, aspect_ratio) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (url) DO NOTHING RETURNING id)
+		(INSERT INTO medias (media_type, title, artist, duration, url, aspect_ratio, permalink, thumbnail) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (url) DO NOTHING RETURNING id)
 		SELECT id FROM ins
 		UNION ALL
     SELECT id FROM medias WHERE url = $5
 		LIMIT 1`,
-		string(entry.Kind()), entry.Title(), entry.Artist(), int(entry.Duration().Seconds()), entry.URL().String(), entry.AspectRatio()).Scan(nil, &id)
+		string(entry.Kind()), entry.Title(), entry.Artist(), int(entry.Duration().Seconds()), entry.URL().String(), entry.AspectRatio(), getPermalink(entry), getThumbnail(entry)).Scan(nil, &id)
 	return id, hasErr
 }
 
-func SetMediaAltMetadata(tx *db.Tx, title, artist string, playlist, media int) (hasErr bool) {
+// Store the media canonMedia refers to (or all entries of it, if it is a
+// media list) in the database, returning their IDs in order. Media already in
+// the database are not resolved again.
+func ResolveMedias(ctx context.Context, tx *db.Tx, canonMedia media.CanonicalizedMediaObject) (resolved media.ResolvedMediaObject, mediaIds []int, hasErr bool) {
+	url := canonMedia.URL().String()
+	resolved, hasRow, hasErr := GetResolvedMedia(tx, url)
+	if hasErr {
+		return nil, nil, true
+	}
+
+	if hasRow {
+		id, _, hasErr := GetMediaId(tx, url)
+		return resolved, []int{id}, hasErr
+	}
+
+	resolved, err := media.Resolve(ctx, canonMedia)
+	if err != nil {
+		tx.PublicError(http.StatusUnprocessableEntity, err)
+		return nil, nil, true
+	}
+
+	entries := resolved.ChildEntries()
+	if single, ok := resolved.(media.ResolvedMediaObjectSingle); ok {
+		entries = []media.ResolvedMediaObjectSingle{single}
+	}
+
+	for _, entry := range entries {
+		id, hasErr := AddMedia(tx, entry)
+		if hasErr {
+			return nil, nil, true
+		}
+
+		mediaIds = append(mediaIds, id)
+	}
+
+	return resolved, mediaIds, false
+}
+
+// Invalid values show the metadata of the media itself.
+func SetMediaAltMetadata(tx *db.Tx, title, artist sql.NullString, playlist, media int) (hasErr bool) {
 	return tx.Exec(nil, "INSERT INTO alt_metadata (playlist, media, alt_title, alt_artist) VALUES ($1, $2, $3, $4) ON CONFLICT (playlist, media) DO UPDATE SET alt_title = excluded.alt_title, alt_artist = excluded.alt_artist", playlist, media, title, artist)
 }
 
 func NotifyMediaChanged(tx *db.Tx, playlist int, socketId string) (callback func(), hasErr bool) {
 	var payload MediaChangedPayload
 	var hasRow bool
-	if tx.QueryRow("SELECT m.media_type, m.url, m.aspect_ratio, p.current_version FROM playlists p JOIN playlist_items i ON p.current = i.id JOIN medias m ON m.id = i.media WHERE p.id = $1", playlist).Scan(&hasRow, &payload.Type, &payload.Url, &payload.AspectRatio, &payload.NewVersion) {
+	if tx.QueryRow("SELECT m.media_type, m.url, m.aspect_ratio, p.current_version, COALESCE(i.start_offset, 0), COALESCE(i.end_offset, 0) FROM playlists p JOIN playlist_items i ON p.current = i.id JOIN medias m ON m.id = i.media WHERE p.id = $1", playlist).Scan(&hasRow, &payload.Type, &payload.Url, &payload.AspectRatio, &payload.NewVersion, &payload.Start, &payload.End) {
 		return nil, true
 	}
 
@@ -121,15 +192,226 @@ func UpdateMedia(tx *db.Tx, id int, entry media.ResolvedMediaObjectSingle) (hasE
 		     artist = $3,
 		     duration = $4,
 		     url = $5,
-		     aspect_ratio = $6
-		 WHERE id = $7`,
+		     aspect_ratio = $6,
+		     permalink = $7,
+		     thumbnail = $8,
+		     status = 'available',
+		     status_reason = NULL,
+		     last_checked = NOW()
+		 WHERE id = $9`,
 		string(entry.Kind()),
 		entry.Title(),
 		entry.Artist(),
 		int(entry.Duration().Seconds()),
 		entry.URL().String(),
 		entry.AspectRatio(),
+		getPermalink(entry),
+		getThumbnail(entry),
 		id,
 	)
-	return hasErr
+	if hasErr {
+		return true
+	}
+
+	return updateMediaPlaylistCounters(tx, id)
+}
+
+// Move all playlist items, job items, alt metadata and playlist covers of
+// media `from` to media `into`, then delete `from`. Used when a media turns
+// out to be a duplicate of another one after its canonical URL changed.
+func MergeMedia(tx *db.Tx, from, into int) (hasErr bool) {
+	if tx.Exec(nil, "UPDATE playlist_items SET media = $1 WHERE media = $2", into, from) {
+		return true
+	}
+
+	if updateMediaPlaylistCounters(tx, into) {
+		return true
+	}
+
+	if tx.Exec(nil, "UPDATE job_items SET media = $1 WHERE media = $2", into, from) {
+		return true
+	}
+
+	if tx.Exec(nil, `
+		INSERT INTO alt_metadata (playlist, media, alt_title, alt_artist)
+		SELECT playlist, $1, alt_title, alt_artist FROM alt_metadata WHERE media = $2
+		ON CONFLICT (playlist, media) DO NOTHING`, into, from) {
+		return true
+	}
+
+	if tx.Exec(nil, "DELETE FROM alt_metadata WHERE media = $1", from) {
+		return true
+	}
+
+	if tx.Exec(nil, "UPDATE playlists SET cover_media = $1 WHERE cover_media = $2", into, from) {
+		return true
+	}
+
+	return tx.Exec(nil, "DELETE FROM medias WHERE id = $1", from)
+}
+
+type MediaStatus string
+
+const (
+	MediaStatusUnknown MediaStatus = "unknown"
+	MediaAvailable     MediaStatus = "available"
+	MediaUnavailable   MediaStatus = "unavailable"
+)
+
+var mediaNoLongerSingleError = errors.New("Media no longer a single media entry")
+
+// Set the availability status of a media, returning whether it changed.
+func SetMediaStatus(tx *db.Tx, id int, status MediaStatus, reason string) (changed, hasErr bool) {
+	var oldStatus MediaStatus
+	if tx.QueryRow("SELECT status FROM medias WHERE id = $1 FOR UPDATE", id).Scan(nil, &oldStatus) {
+		return false, true
+	}
+
+	if tx.Exec(nil, "UPDATE medias SET status = $2, status_reason = NULLIF($3, ''), last_checked = NOW() WHERE id = $1", id, string(status), reason) {
+		return false, true
+	}
+
+	return oldStatus != status, false
+}
+
+type MediaCheckResult struct {
+	// ID of the checked media, which differs from the original one if it was
+	// merged into another media
+	Id            int
+	ResolveErr    error
+	StatusChanged bool
+}
+
+// A media re-resolved by ResolveMediaCheck, to be stored by CheckMedia.
+type MediaCheck struct {
+	Id       int
+	URL      string
+	resolved media.ResolvedMediaObjectSingle
+	err      error
+}
+
+// Re-resolve a media. Resolving goes over the network, so it is done outside
+// of the transaction storing the result, which would otherwise hold its locks
+// (and a connection) for as long as the media source takes to answer.
+func ResolveMediaCheck(ctx context.Context, handler errs.ErrorHandler, id int) (check MediaCheck, hasRow, hasErr bool) {
+	tx := db.BeginTx(handler)
+	if tx == nil {
+		return check, false, true
+	}
+
+	check.Id = id
+	check.URL, hasRow, hasErr = GetMediaUrl(tx, id)
+	tx.Rollback()
+	if hasErr || !hasRow {
+		return check, hasRow, hasErr
+	}
+
+	check.resolved, check.err = resolveMediaFresh(ctx, check.URL)
+	return check, true, false
+}
+
+// Store the result of ResolveMediaCheck, updating the metadata and
+// availability status of the media. If it could not be resolved, ResolveErr is
+// set and the media is marked unavailable if the error says it is gone for
+// good.
+func CheckMedia(tx *db.Tx, check MediaCheck) (result MediaCheckResult, hasErr bool) {
+	id, url, resolved, err := check.Id, check.URL, check.resolved, check.err
+	result.Id = id
+
+	// the media might have been merged into another one while resolving
+	var oldStatus MediaStatus
+	var hasRow bool
+	if tx.QueryRow("SELECT status FROM medias WHERE id = $1 FOR UPDATE", id).Scan(&hasRow, &oldStatus) {
+		return result, true
+	}
+
+	if !hasRow {
+		result.ResolveErr = media.ErrMediaNotFound
+		return result, false
+	}
+
+	if err != nil {
+		result.ResolveErr = err
+		status := oldStatus
+		if media.IsUnavailableError(err) {
+			status = MediaUnavailable
+		}
+
+		result.StatusChanged, hasErr = SetMediaStatus(tx, id, status, err.Error())
+		return result, hasErr
+	}
+
+	// the canonical URL might have changed (e.g. SoundCloud permalinks being
+	// migrated to track IDs), merge into the existing media if there is one
+	if newUrl := resolved.URL().String(); newUrl != url {
+		existingId, hasRow, hasErr := GetMediaId(tx, newUrl)
+		if hasErr {
+			return result, true
+		}
+
+		if hasRow && existingId != id {
+			if MergeMedia(tx, id, existingId) {
+				return result, true
+			}
+
+			result.Id = existingId
+		}
+	}
+
+	if UpdateMedia(tx, result.Id, resolved) {
+		return result, true
+	}
+
+	result.StatusChanged = oldStatus != MediaAvailable
+	return result, false
+}
+
+func resolveMediaFresh(ctx context.Context, url string) (media.ResolvedMediaObjectSingle, error) {
+	object, err := media.ProcessURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	canonMedia, err := media.Canonicalize(ctx, object)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := media.ResolveFresh(ctx, canonMedia)
+	if err != nil {
+		return nil, err
+	}
+
+	single, ok := resolved.(media.ResolvedMediaObjectSingle)
+	if !ok {
+		return nil, mediaNoLongerSingleError
+	}
+
+	return single, nil
+}
+
+// Tell all playlists containing a media to refresh, once callback is called.
+func NotifyMediaPlaylists(tx *db.Tx, id int) (callback func(), hasErr bool) {
+	var rows *sql.Rows
+	if tx.Query(&rows, "SELECT DISTINCT playlist FROM playlist_items WHERE media = $1", id) {
+		return nil, true
+	}
+	defer rows.Close()
+
+	var playlists []int
+	for rows.Next() {
+		var playlist int
+		if err := rows.Scan(&playlist); err != nil {
+			tx.PrivateError(err)
+			return nil, true
+		}
+
+		playlists = append(playlists, playlist)
+	}
+
+	return func() {
+		for _, playlist := range playlists {
+			WebSocketPlaylistEvent(playlist, PlaylistChanged)
+		}
+	}, false
 }
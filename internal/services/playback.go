@@ -0,0 +1,126 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/errs"
+	"github.com/btmxh/plst4/internal/html"
+)
+
+const maxPlaybackErrorReasonLength = 200
+
+type PlaybackErrorPayload struct {
+	// current_version of the playlist when the player failed
+	Version int    `json:"version"`
+	Reason  string `json:"reason"`
+}
+
+// Record that a logged-in viewer failed to play version, returning whether at
+// least half of the logged-in viewers reported it. Only the report reaching
+// that threshold returns true, so that the media is skipped once.
+func (manager *WebSocketManager) reportPlaybackError(playlist int, username string, version int) bool {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	p, ok := manager.playlists[playlist]
+	if !ok {
+		return false
+	}
+
+	if p.failedVersion != version {
+		p.failedVersion = version
+		p.failureHandled = false
+		clear(p.failureReports)
+	}
+
+	if p.failureHandled {
+		return false
+	}
+
+	p.failureReports[username] = struct{}{}
+	if len(p.failureReports)*2 < p.NumManagerWatching() {
+		return false
+	}
+
+	p.failureHandled = true
+	return true
+}
+
+func playbackErrorReason(reason string) string {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return "playback failed"
+	}
+
+	if runes := []rune(reason); len(runes) > maxPlaybackErrorReasonLength {
+		return string(runes[:maxPlaybackErrorReasonLength]) + "..."
+	}
+
+	return reason
+}
+
+// Handle a playback failure reported by a viewer. Once enough viewers failed
+// to play the current media, it is marked unavailable and the playlist moves
+// on to the next playable media.
+//
+// Reports of anonymous viewers are ignored: anyone can open as many anonymous
+// sockets as they want, so they could skip any media on their own.
+func ReportPlaybackError(tx *db.Tx, handler errs.ErrorHandler, playlist int, username string, payload PlaybackErrorPayload) (callback func(), hasErr bool) {
+	if username == "" {
+		return func() {}, false
+	}
+
+	var version, mediaId int
+	var title string
+	var hasRow bool
+	if tx.QueryRow(`
+		SELECT p.current_version, m.id, COALESCE(a.alt_title, m.title)
+		FROM playlists p
+		JOIN playlist_items i ON i.id = p.current
+		JOIN medias m ON m.id = i.media
+		LEFT JOIN alt_metadata a ON a.media = m.id AND a.playlist = p.id
+		WHERE p.id = $1`, playlist).Scan(&hasRow, &version, &mediaId, &title) {
+		return nil, true
+	}
+
+	// reports of media that was already changed are stale
+	if !hasRow || version != payload.Version || !manager.reportPlaybackError(playlist, username, version) {
+		return func() {}, false
+	}
+
+	reason := playbackErrorReason(payload.Reason)
+	if _, hasErr = SetMediaStatus(tx, mediaId, MediaUnavailable, reason); hasErr {
+		return nil, true
+	}
+
+	notifyPlaylists, hasErr := NotifyMediaPlaylists(tx, mediaId)
+	if hasErr {
+		return nil, true
+	}
+
+	// skipping to the same media over and over again is pointless
+	var hasPlayable bool
+	if tx.QueryRow("SELECT EXISTS (SELECT 1 FROM playlist_items i JOIN medias m ON m.id = i.media WHERE i.playlist = $1 AND m.status <> 'unavailable')", playlist).Scan(nil, &hasPlayable) {
+		return nil, true
+	}
+
+	mediaChanged := func() {}
+	if hasPlayable {
+		if mediaChanged, hasErr = PlaylistUpdateCurrent(tx, handler, playlist, ">", "ASC"); hasErr {
+			return nil, true
+		}
+	}
+
+	return func() {
+		notifyPlaylists()
+		mediaChanged()
+
+		if hasPlayable {
+			WebSocketPlaylistToast(playlist, html.ToastError, "Skipped unavailable media", html.StringAsHTML(fmt.Sprintf("'%s' could not be played: %s", title, reason)))
+		} else {
+			WebSocketPlaylistToast(playlist, html.ToastError, "Media unavailable", html.StringAsHTML(fmt.Sprintf("'%s' could not be played: %s. There is no other playable media in this playlist.", title, reason)))
+		}
+	}, false
+}
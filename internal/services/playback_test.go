@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func newTestPlaybackManager(viewers map[string]int) *WebSocketManager {
+	state := &PlaylistState{
+		userSockets:    make(map[string]map[string]*websocket.Conn),
+		nextRequested:  make(map[string]struct{}),
+		failureReports: make(map[string]struct{}),
+	}
+
+	for username, numSockets := range viewers {
+		state.userSockets[username] = make(map[string]*websocket.Conn)
+		for i := range numSockets {
+			state.userSockets[username][username+string(rune('a'+i))] = nil
+		}
+	}
+
+	return &WebSocketManager{playlists: map[int]*PlaylistState{1: state}}
+}
+
+func TestReportPlaybackError(t *testing.T) {
+	m := newTestPlaybackManager(map[string]int{"alice": 2, "bob": 1, "carol": 1, "dave": 1, "": 10})
+
+	if m.reportPlaybackError(1, "alice", 1) {
+		t.Fatal("expected one report of four viewers not to skip")
+	}
+
+	// reporting again, e.g. from another socket, does not count twice
+	if m.reportPlaybackError(1, "alice", 1) {
+		t.Fatal("expected repeated report not to count")
+	}
+
+	if !m.reportPlaybackError(1, "bob", 1) {
+		t.Fatal("expected half of the viewers to skip")
+	}
+
+	if m.reportPlaybackError(1, "carol", 1) {
+		t.Fatal("expected the media to be skipped only once")
+	}
+
+	// reports of another version start over
+	if m.reportPlaybackError(1, "carol", 2) {
+		t.Fatal("expected reports of the previous version to be cleared")
+	}
+}
+
+func TestReportPlaybackErrorAnonymous(t *testing.T) {
+	// anonymous reports are dropped before touching the database
+	callback, hasErr := ReportPlaybackError(nil, nil, 1, "", PlaybackErrorPayload{Version: 1})
+	if hasErr || callback == nil {
+		t.Fatalf("expected anonymous report to be ignored, got hasErr = %v", hasErr)
+	}
+}
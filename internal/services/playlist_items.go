@@ -8,15 +8,21 @@ import (
 	"time"
 
 	"github.com/btmxh/plst4/internal/db"
+	"github.com/btmxh/plst4/internal/media"
+	"github.com/lib/pq"
 )
 
 type QueuePlaylistItem struct {
-	Title    string
-	Artist   string
-	URL      string
+	Title   string
+	Artist  string
+	URL     string
+	MediaId int
+	// length of the trimmed part of the media
 	Duration time.Duration
-	Id       int
-	Index    int
+	// set if the media was found to be no longer available
+	Unavailable bool
+	Id          int
+	Index       int
 }
 
 type MoveDirection int
@@ -31,11 +37,54 @@ type MoveItem struct {
 	order int
 }
 
+// Length of the trimmed part of item i of media m, in seconds.
+const itemLengthSQL = "GREATEST(COALESCE(i.end_offset, m.duration) - COALESCE(i.start_offset, 0), 0)"
+
+// Recompute the item count and total duration of the playlists matching
+// condition, a condition on playlists p.
+func updatePlaylistCounters(tx *db.Tx, result *sql.Result, condition string, args ...any) (hasErr bool) {
+	return tx.Exec(result, `
+		UPDATE playlists p
+		SET item_count = s.item_count, total_duration = s.total_duration
+		FROM (
+			SELECT p.id, COUNT(i.id) AS item_count, COALESCE(SUM(`+itemLengthSQL+`), 0) AS total_duration
+			FROM playlists p
+			LEFT JOIN playlist_items i ON i.playlist = p.id
+			LEFT JOIN medias m ON m.id = i.media
+			WHERE `+condition+`
+			GROUP BY p.id
+		) s
+		WHERE p.id = s.id AND (p.item_count, p.total_duration) IS DISTINCT FROM (s.item_count, s.total_duration)`, args...)
+}
+
+// Recompute the counters of the playlists containing media, after its
+// duration or its items changed.
+func updateMediaPlaylistCounters(tx *db.Tx, media int) (hasErr bool) {
+	return updatePlaylistCounters(tx, nil, "p.id IN (SELECT playlist FROM playlist_items WHERE media = $1)", media)
+}
+
+// Recompute the counters of every playlist, returning the number of
+// playlists whose counters were wrong.
+func RepairPlaylistCounters(tx *db.Tx) (numRepaired int, hasErr bool) {
+	var res sql.Result
+	if updatePlaylistCounters(tx, &res, "TRUE") {
+		return 0, true
+	}
+
+	numAffected, err := res.RowsAffected()
+	if err != nil {
+		tx.PrivateError(err)
+		return 0, true
+	}
+
+	return int(numAffected), false
+}
+
 func EnumeratePlaylistItems(tx *db.Tx, playlist int, pageNum int) (page Pagination[QueuePlaylistItem], hasError bool) {
 	if pageNum == 0 {
 		// last page
 		var itemCount int
-		if tx.QueryRow("SELECT COUNT(*) FROM playlist_items WHERE playlist = $1", playlist).Scan(nil, &itemCount) {
+		if tx.QueryRow("SELECT item_count FROM playlists WHERE id = $1", playlist).Scan(nil, &itemCount) {
 			return page, true
 		}
 
@@ -52,8 +101,10 @@ func EnumeratePlaylistItems(tx *db.Tx, playlist int, pageNum int) (page Paginati
         i.id, 
         COALESCE(a.alt_title, m.title),
         COALESCE(a.alt_artist, m.artist),
-        m.url, 
-        m.duration 
+        COALESCE(m.permalink, m.url),
+        m.id,
+        GREATEST(COALESCE(i.end_offset, m.duration) - COALESCE(i.start_offset, 0), 0),
+        m.status = 'unavailable'
     FROM playlist_items i 
     JOIN medias m ON m.id = i.media 
 		LEFT JOIN alt_metadata a ON a.media = m.id AND a.playlist = i.playlist
@@ -66,7 +117,7 @@ func EnumeratePlaylistItems(tx *db.Tx, playlist int, pageNum int) (page Paginati
 	for index := offset; rows.Next(); index += 1 {
 		var item QueuePlaylistItem
 		var duration time.Duration
-		err := rows.Scan(&item.Id, &item.Title, &item.Artist, &item.URL, &duration)
+		err := rows.Scan(&item.Id, &item.Title, &item.Artist, &item.URL, &item.MediaId, &duration, &item.Unavailable)
 		if err != nil {
 			tx.PrivateError(err)
 			return page, true
@@ -148,7 +199,84 @@ func AddPlaylistItems(tx *db.Tx, playlist int, mediaIds []int, beginOrder, delta
 		ids = append(ids, itemId)
 	}
 
-	return ids, false
+	if len(ids) == 0 {
+		return ids, false
+	}
+
+	return ids, tx.Exec(nil, `
+		UPDATE playlists SET
+			item_count = item_count + $2,
+			total_duration = total_duration + (
+				SELECT COALESCE(SUM(`+itemLengthSQL+`), 0)
+				FROM playlist_items i
+				JOIN medias m ON m.id = i.media
+				WHERE i.id = ANY($3)
+			)
+		WHERE id = $1`, playlist, len(ids), pq.Array(ids))
+}
+
+// Insert medias into playlist at pos, keeping their relative order.
+func InsertPlaylistItems(tx *db.Tx, playlist int, mediaIds []int, pos PlaylistAddPosition) (ids []int, hasErr bool) {
+	if len(mediaIds) == 0 {
+		return ids, false
+	}
+
+	var current sql.NullInt32
+	if pos == QueueNext {
+		if tx.QueryRow("SELECT current FROM playlists WHERE id = $1", playlist).Scan(nil, &current) {
+			return ids, true
+		}
+		if !current.Valid {
+			pos = AddToEnd
+		}
+	}
+
+	var begin int
+	var hasRow bool
+	delta := PlaylistAddOrderGap
+	if pos != QueueNext {
+		var minOrder int
+		var maxOrder int
+		if tx.QueryRow("SELECT COALESCE(MIN(item_order), 0), COALESCE(MAX(item_order), 0) FROM playlist_items WHERE playlist = $1", playlist).Scan(&hasRow, &minOrder, &maxOrder) {
+			return ids, true
+		}
+
+		if hasRow {
+			if pos == AddToStart {
+				begin = minOrder - delta*len(mediaIds)
+			} else {
+				begin = maxOrder + delta
+			}
+		} else {
+			begin = 0
+		}
+	} else {
+		prev := int(current.Int32)
+		var prevOrder, nextOrder int
+		prevOrder, hasErr = GetPlaylistItemOrder(tx, prev)
+		if hasErr {
+			return ids, true
+		}
+
+		var next int
+		next, nextOrder, hasRow, hasErr = GetNextPlaylistItem(tx, playlist, prevOrder)
+		if hasErr {
+			return ids, true
+		}
+
+		if !hasRow {
+			begin = prevOrder + delta
+		} else {
+			slog.Info("local rebalancing", "playlist", playlist, "prev_order", prevOrder, "next_order", nextOrder, "prev", prev, "next", next, "len", len(mediaIds))
+			begin, delta, hasErr = LocalRebalance(tx, playlist, prevOrder, nextOrder, prev, len(mediaIds))
+			if hasErr {
+				return ids, true
+			}
+		}
+	}
+
+	slog.Info("Adding media to playlist", "playlist", playlist, "mediaIds", mediaIds, "begin", begin, "delta", delta)
+	return AddPlaylistItems(tx, playlist, mediaIds, begin, delta)
 }
 
 func GetPlaylistItemOrder(tx *db.Tx, id int) (order int, hasErr bool) {
@@ -173,7 +301,17 @@ func CheckPlaylistItemExists(tx *db.Tx, playlist, id int) (hasRow bool, hasErr b
 }
 
 func DeletePlaylistItem(tx *db.Tx, playlist int, id int) (hasErr bool) {
-	return tx.Exec(nil, "DELETE FROM playlist_items WHERE playlist = $1 AND id = $2", playlist, id)
+	return tx.Exec(nil, `
+		WITH deleted AS (
+			DELETE FROM playlist_items i
+			USING medias m
+			WHERE i.playlist = $1 AND i.id = $2 AND m.id = i.media
+			RETURNING `+itemLengthSQL+` AS length
+		)
+		UPDATE playlists SET
+			item_count = item_count - (SELECT COUNT(*) FROM deleted),
+			total_duration = total_duration - (SELECT COALESCE(SUM(length), 0) FROM deleted)
+		WHERE id = $1`, playlist, id)
 }
 
 func MoveItems(tx *db.Tx, playlist int, items []int, dir MoveDirection) (numAffected int, hasErr bool) {
@@ -228,3 +366,30 @@ func MoveItems(tx *db.Tx, playlist int, items []int, dir MoveDirection) (numAffe
 
 	return len(affected), false
 }
+
+func SetPlaylistItemTrim(tx *db.Tx, item int, trim media.Trim) (hasErr bool) {
+	// both statements see the item before it is updated
+	return tx.Exec(nil, `
+		WITH old AS (
+			SELECT `+itemLengthSQL+` AS length
+			FROM playlist_items i
+			JOIN medias m ON m.id = i.media
+			WHERE i.id = $1
+		), updated AS (
+			UPDATE playlist_items i
+			SET start_offset = NULLIF($2, 0), end_offset = NULLIF($3, 0)
+			FROM medias m
+			WHERE i.id = $1 AND m.id = i.media
+			RETURNING i.playlist, `+itemLengthSQL+` AS length
+		)
+		UPDATE playlists p
+		SET total_duration = p.total_duration + updated.length - old.length
+		FROM updated, old
+		WHERE p.id = updated.playlist`, item, int(trim.Start.Seconds()), int(trim.End.Seconds()))
+}
+
+func GetPlaylistItemTrim(tx *db.Tx, item int) (trim media.Trim, hasErr bool) {
+	var start, end int
+	hasErr = tx.QueryRow("SELECT COALESCE(start_offset, 0), COALESCE(end_offset, 0) FROM playlist_items WHERE id = $1", item).Scan(nil, &start, &end)
+	return media.Trim{Start: time.Duration(start) * time.Second, End: time.Duration(end) * time.Second}, hasErr
+}
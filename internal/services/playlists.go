@@ -5,11 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/btmxh/plst4/internal/db"
 	"github.com/btmxh/plst4/internal/errs"
 	"github.com/btmxh/plst4/internal/media"
+	"github.com/lib/pq"
 )
 
 type PlaylistAddPosition string
@@ -39,10 +45,59 @@ const (
 	Managed PlaylistFilter = "managed"
 )
 
+type PlaylistVisibility string
+
+const (
+	Public   PlaylistVisibility = "public"
+	Unlisted PlaylistVisibility = "unlisted"
+	Private  PlaylistVisibility = "private"
+)
+
+func ParsePlaylistVisibility(visibility string) (PlaylistVisibility, error) {
+	switch visibility {
+	case string(Public), string(Unlisted), string(Private):
+		return PlaylistVisibility(visibility), nil
+	default:
+		return "", fmt.Errorf("Invalid visibility: %s", visibility)
+	}
+}
+
+const (
+	MaxPlaylistTags              = 10
+	MaxPlaylistTagLength         = 50
+	MaxPlaylistDescriptionLength = 2000
+)
+
+var TooManyTagsError = fmt.Errorf("Playlists can have at most %d tags.", MaxPlaylistTags)
+var TagTooLongError = fmt.Errorf("Tags must be at most %d characters long.", MaxPlaylistTagLength)
+var DescriptionTooLongError = fmt.Errorf("Descriptions must be at most %d characters long.", MaxPlaylistDescriptionLength)
+
 var NoCurrentMediaError = errors.New("No current media.")
 var AlreadyPlaylistOwnerError = errors.New("This user (you) is already the playlist owner.")
 var AlreadyPlaylistManagerError = errors.New("This user is already a playlist manager.")
 var UserNotFoundError = errors.New("User not found.")
+var SelfMergeError = errors.New("A playlist cannot be merged into itself.")
+
+type PlaylistSort string
+
+const (
+	// the default order of the filter without a query
+	SortRelevance      PlaylistSort = "relevance"
+	SortNewest         PlaylistSort = "newest"
+	SortMostItems      PlaylistSort = "items"
+	SortRecentlyPlayed PlaylistSort = "played"
+)
+
+func ParsePlaylistSort(sort string) (PlaylistSort, error) {
+	switch sort {
+	case "":
+		return SortRelevance, nil
+	case string(SortRelevance), string(SortNewest), string(SortMostItems), string(SortRecentlyPlayed):
+		return PlaylistSort(sort), nil
+	default:
+		return "", fmt.Errorf("Invalid sort order: %s", sort)
+	}
+}
 
 func ParsePlaylistFilter(filter string) (PlaylistFilter, error) {
 	switch filter {
@@ -62,6 +117,9 @@ type QueriedPlaylist struct {
 	TotalLength      time.Duration
 	CurrentPlaying   string
 	Thumbnail        string
+	Visibility       PlaylistVisibility
+	Description      string
+	Tags             []string
 }
 
 func IsPlaylistOwner(tx *db.Tx, username string, playlist int) (isOwner, hasErr bool) {
@@ -85,74 +143,119 @@ func IsPlaylistManager(tx *db.Tx, username string, playlist int) (isManager, has
 	return isManager, false
 }
 
-func SearchPlaylists(tx *db.Tx, username string, query string, filter PlaylistFilter, offset int) (page Pagination[QueriedPlaylist], hasError bool) {
-	var rows *sql.Rows
-	var hasErr bool
+// Turn a search query into a tsquery matching texts with words starting with
+// each word of the query.
+func searchTsQuery(query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	for i, word := range words {
+		words[i] = "'" + word + "':*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Playlists match a query if their name contains it, or if their name,
+// description or the metadata of one of their medias contains its words.
+func SearchPlaylists(tx *db.Tx, username string, query string, tag string, filter PlaylistFilter, sort PlaylistSort, offset int) (page Pagination[QueriedPlaylist], hasError bool) {
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	user := arg(username)
+	var conditions []string
+	defaultOrder := "sort_created, playlist_id"
 	switch filter {
 	case All:
-		hasErr = tx.Query(&rows,
-			`SELECT p.id, p.name, p.owner_username, p.created_timestamp, m.url,
-							COALESCE(a.alt_title, m.title),
-              COALESCE(a.alt_artist, m.artist),
-              COALESCE((SELECT COUNT(*) FROM playlist_items i WHERE i.playlist = p.id), 0),
-							COALESCE((SELECT SUM(m.duration) FROM playlist_items i JOIN medias m ON m.id = i.media WHERE i.playlist = p.id), 0)
-         FROM playlists p
-				 LEFT JOIN playlist_items i ON i.id = p.current
-				 LEFT JOIN medias m ON m.id = i.media
-				 LEFT JOIN alt_metadata a ON a.media = m.id AND a.playlist = p.id
-         WHERE POSITION($1 IN LOWER(name)) > 0 
-         ORDER BY created_timestamp DESC
-         LIMIT $2 OFFSET $3`,
-			query, DefaultPagingLimit+1, offset,
-		)
-		break
+		conditions = append(conditions, "(p.visibility = 'public' OR p.owner_username = "+user+" OR "+user+" IN (SELECT username FROM playlist_manage WHERE playlist = p.id))")
+		defaultOrder = "sort_created DESC, playlist_id DESC"
 	case Owned:
-		hasErr = tx.Query(&rows,
-			`SELECT p.id, p.name, p.owner_username, p.created_timestamp, m.url,
-							COALESCE(a.alt_title, m.title),
-              COALESCE(a.alt_artist, m.artist),
-              COALESCE((SELECT COUNT(*) FROM playlist_items i WHERE i.playlist = p.id), 0),
-							COALESCE((SELECT SUM(m.duration) FROM playlist_items i JOIN medias m ON m.id = i.media WHERE i.playlist = p.id), 0)
-         FROM playlists p
-				 LEFT JOIN playlist_items i ON i.id = p.current
-				 LEFT JOIN medias m ON m.id = i.media
-				 LEFT JOIN alt_metadata a ON a.media = m.id AND a.playlist = p.id
-         WHERE POSITION($1 IN LOWER(name)) > 0 
-           AND owner_username = $4 
-         ORDER BY created_timestamp 
-         LIMIT $2 OFFSET $3`,
-			query, DefaultPagingLimit+1, offset, username,
-		)
-		break
+		conditions = append(conditions, "p.owner_username = "+user)
 	case Managed:
-		hasErr = tx.Query(&rows,
-			`SELECT p.id, p.name, p.owner_username, p.created_timestamp, m.url,
-							COALESCE(a.alt_title, m.title),
-              COALESCE(a.alt_artist, m.artist),
-              COALESCE((SELECT COUNT(*) FROM playlist_items i WHERE i.playlist = p.id), 0),
-							COALESCE((SELECT SUM(m.duration) FROM playlist_items i JOIN medias m ON m.id = i.media WHERE i.playlist = p.id), 0)
-         FROM playlists p
-				 LEFT JOIN playlist_items i ON i.id = p.current
-				 LEFT JOIN medias m ON m.id = i.media
-				 LEFT JOIN alt_metadata a ON a.media = m.id AND a.playlist = p.id
-         WHERE POSITION($1 IN LOWER(name)) > 0 
-           AND (owner_username = $4 OR $4 IN (SELECT username FROM playlist_manage WHERE playlist = p.id))
-         ORDER BY created_timestamp 
-         LIMIT $2 OFFSET $3`,
-			query, DefaultPagingLimit+1, offset, username,
-		)
+		conditions = append(conditions, "(p.owner_username = "+user+" OR "+user+" IN (SELECT username FROM playlist_manage WHERE playlist = p.id))")
 	}
 
-	if hasErr {
+	if tag != "" {
+		conditions = append(conditions, "p.id IN (SELECT playlist FROM playlist_tags WHERE tag = "+arg(tag)+")")
+	}
+
+	rank := "0"
+	if query != "" {
+		raw := arg(query)
+		matches := []string{"LOWER(p.name) LIKE " + arg("%"+likeEscaper.Replace(query)+"%")}
+		rank = "similarity(LOWER(p.name), " + raw + ")"
+		if tsQuery := searchTsQuery(query); tsQuery != "" {
+			q := "to_tsquery('simple', " + arg(tsQuery) + ")"
+			matches = append(matches,
+				"p.search_vector @@ "+q,
+				"p.id IN (SELECT i.playlist FROM playlist_items i JOIN medias m ON m.id = i.media WHERE m.search_vector @@ "+q+")",
+				"p.id IN (SELECT a.playlist FROM alt_metadata a JOIN playlist_items i ON i.playlist = a.playlist AND i.media = a.media WHERE a.search_vector @@ "+q+")",
+			)
+			rank = "ts_rank(p.search_vector, " + q + ") + " + rank
+		}
+
+		conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+	}
+
+	var order string
+	switch sort {
+	case SortNewest:
+		order = "sort_created DESC, playlist_id DESC"
+	case SortMostItems:
+		order = "num_items DESC, sort_created DESC, playlist_id DESC"
+	case SortRecentlyPlayed:
+		order = "sort_played DESC NULLS LAST, sort_created DESC, playlist_id DESC"
+	default:
+		order = defaultOrder
+		if query != "" {
+			order = "search_rank DESC, " + defaultOrder
+		}
+	}
+
+	where := "TRUE"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	var rows *sql.Rows
+	if tx.Query(&rows, `
+		SELECT p.id, p.name, p.owner_username, p.created_timestamp, p.visibility, p.description,
+			ARRAY(SELECT t.tag FROM playlist_tags t WHERE t.playlist = p.id ORDER BY t.tag),
+			p.cover_file, p.cover_media, m.id,
+			COALESCE(a.alt_title, m.title),
+			COALESCE(a.alt_artist, m.artist),
+			p.item_count,
+			p.total_duration
+		FROM (
+			SELECT p.id AS playlist_id, p.created_timestamp AS sort_created, p.last_played AS sort_played,
+				`+rank+` AS search_rank, p.item_count AS num_items
+			FROM playlists p
+			WHERE `+where+`
+			ORDER BY `+order+`
+			LIMIT `+arg(DefaultPagingLimit+1)+` OFFSET `+arg(offset)+`
+		) r
+		JOIN playlists p ON p.id = r.playlist_id
+		LEFT JOIN playlist_items i ON i.id = p.current
+		LEFT JOIN medias m ON m.id = i.media
+		LEFT JOIN alt_metadata a ON a.media = m.id AND a.playlist = p.id
+		ORDER BY `+order, args...) {
 		return
 	}
 
 	var playlists []QueriedPlaylist
 	for rows.Next() {
-		var mediaUrl, mediaTitle, mediaArtist sql.NullString
+		var coverFile sql.NullString
+		var coverMedia, mediaId sql.NullInt32
+		var mediaTitle, mediaArtist sql.NullString
 		var playlist QueriedPlaylist
 		var totalLength int
-		if err := rows.Scan(&playlist.Id, &playlist.Name, &playlist.OwnerUsername, &playlist.CreatedTimestamp, &mediaUrl, &mediaTitle, &mediaArtist, &playlist.ItemCount, &totalLength); err != nil {
+		if err := rows.Scan(&playlist.Id, &playlist.Name, &playlist.OwnerUsername, &playlist.CreatedTimestamp, &playlist.Visibility, &playlist.Description, pq.Array(&playlist.Tags), &coverFile, &coverMedia, &mediaId, &mediaTitle, &mediaArtist, &playlist.ItemCount, &totalLength); err != nil {
 			tx.PrivateError(err)
 			tx.PublicError(http.StatusInternalServerError, db.GenericError)
 			return
@@ -161,7 +264,7 @@ func SearchPlaylists(tx *db.Tx, username string, query string, filter PlaylistFi
 		if mediaTitle.Valid && mediaArtist.Valid {
 			playlist.CurrentPlaying = fmt.Sprintf("%s by %s", mediaTitle.String, mediaArtist.String)
 		}
-		playlist.Thumbnail = media.GetThumbnail(mediaUrl)
+		playlist.Thumbnail = PlaylistCoverURL(playlist.Id, coverFile, coverMedia, mediaId)
 		playlist.TotalLength = time.Duration(totalLength) * time.Second
 		playlists = append(playlists, playlist)
 	}
@@ -169,6 +272,130 @@ func SearchPlaylists(tx *db.Tx, username string, query string, filter PlaylistFi
 	return NewPagination(offset, playlists), false
 }
 
+// Tags are case-insensitive and may be written with a leading #.
+func NormalizeTag(tag string) string {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
+
+// Parse comma-separated tags, dropping empty and duplicate ones.
+func ParsePlaylistTags(str string) (tags []string, err error) {
+	for _, tag := range strings.Split(str, ",") {
+		tag = NormalizeTag(tag)
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+
+		if utf8.RuneCountInString(tag) > MaxPlaylistTagLength {
+			return nil, TagTooLongError
+		}
+
+		tags = append(tags, tag)
+	}
+
+	if len(tags) > MaxPlaylistTags {
+		return nil, TooManyTagsError
+	}
+
+	return tags, nil
+}
+
+func GetPlaylistTags(tx *db.Tx, playlist int) (tags []string, hasErr bool) {
+	hasErr = tx.QueryRow("SELECT ARRAY(SELECT tag FROM playlist_tags WHERE playlist = $1 ORDER BY tag)", playlist).Scan(nil, pq.Array(&tags))
+	return tags, hasErr
+}
+
+func SetPlaylistDetails(tx *db.Tx, playlist int, description string, tags []string) (hasErr bool) {
+	if utf8.RuneCountInString(description) > MaxPlaylistDescriptionLength {
+		tx.PublicError(http.StatusUnprocessableEntity, DescriptionTooLongError)
+		return true
+	}
+
+	if tx.Exec(nil, "UPDATE playlists SET description = $1 WHERE id = $2", description, playlist) {
+		return true
+	}
+
+	if tx.Exec(nil, "DELETE FROM playlist_tags WHERE playlist = $1", playlist) {
+		return true
+	}
+
+	for _, tag := range tags {
+		if tx.Exec(nil, "INSERT INTO playlist_tags (playlist, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING", playlist, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Private playlists can only be viewed by their owner and managers. Missing
+// playlists cannot be viewed either.
+func CanViewPlaylist(tx *db.Tx, username string, playlist int) (canView, hasErr bool) {
+	var dummy int
+	hasErr = tx.QueryRow(`
+		SELECT 1 FROM playlists p
+		WHERE p.id = $1 AND (
+			p.visibility <> 'private'
+			OR p.owner_username = $2
+			OR $2 IN (SELECT username FROM playlist_manage WHERE playlist = p.id)
+		)`, playlist, username).Scan(&canView, &dummy)
+	return canView, hasErr
+}
+
+func GetPlaylistVisibility(tx *db.Tx, playlist int) (visibility PlaylistVisibility, hasErr bool) {
+	hasErr = tx.QueryRow("SELECT visibility FROM playlists WHERE id = $1", playlist).Scan(nil, &visibility)
+	return visibility, hasErr
+}
+
+// Change the visibility of playlist, disconnecting viewers who can no longer
+// view it once the callback is run.
+func SetPlaylistVisibility(tx *db.Tx, playlist int, visibility PlaylistVisibility) (callback func(), hasErr bool) {
+	if tx.Exec(nil, "UPDATE playlists SET visibility = $1 WHERE id = $2", visibility, playlist) {
+		return nil, true
+	}
+
+	return DisconnectPlaylistViewers(tx, playlist)
+}
+
+// Get a callback disconnecting the WebSocket viewers of playlist who are not
+// allowed to view it anymore.
+func DisconnectPlaylistViewers(tx *db.Tx, playlist int) (callback func(), hasErr bool) {
+	visibility, hasErr := GetPlaylistVisibility(tx, playlist)
+	if hasErr {
+		return nil, true
+	}
+
+	if visibility != Private {
+		return func() {}, false
+	}
+
+	return disconnectNonManagers(tx, playlist)
+}
+
+func disconnectNonManagers(tx *db.Tx, playlist int) (callback func(), hasErr bool) {
+	owner, hasErr := GetPlaylistOwner(tx, playlist)
+	if hasErr {
+		return nil, true
+	}
+
+	managers, hasErr := EnumeratePlaylistManagers(tx, playlist)
+	if hasErr {
+		return nil, true
+	}
+
+	allowed := map[string]struct{}{owner: {}}
+	for _, manager := range managers {
+		allowed[manager] = struct{}{}
+	}
+
+	return func() {
+		WebSocketDisconnect(playlist, func(username string) bool {
+			_, ok := allowed[username]
+			return !ok
+		})
+	}, false
+}
+
 func CreatePlaylist(tx *db.Tx, username string, name string) (id int, hasError bool) {
 	var hasRow bool
 	if tx.QueryRow("INSERT INTO playlists (name, owner_username) VALUES ($1, $2) RETURNING id", name, username).Scan(&hasRow, &id) || !hasRow {
@@ -178,6 +405,100 @@ func CreatePlaylist(tx *db.Tx, username string, name string) (id int, hasError b
 	return id, false
 }
 
+// Create a playlist owned by username with the items, trims, alternative
+// metadata, visibility, description and tags of source. The copy starts at
+// the current item of source. Uploaded covers are not copied, as they belong
+// to the source playlist.
+func CopyPlaylist(tx *db.Tx, source int, username string, name string) (id int, hasErr bool) {
+	id, hasErr = CreatePlaylist(tx, username, name)
+	if hasErr {
+		return 0, true
+	}
+
+	// copies of private playlists stay private
+	if tx.Exec(nil, "UPDATE playlists SET (visibility, description, cover_media) = (SELECT visibility, description, cover_media FROM playlists WHERE id = $2) WHERE id = $1", id, source) {
+		return 0, true
+	}
+
+	if tx.Exec(nil, "INSERT INTO playlist_tags (playlist, tag) SELECT $1, tag FROM playlist_tags WHERE playlist = $2", id, source) {
+		return 0, true
+	}
+
+	if tx.Exec(nil, "INSERT INTO playlist_items (playlist, media, item_order, start_offset, end_offset) SELECT $1, media, item_order, start_offset, end_offset FROM playlist_items WHERE playlist = $2", id, source) {
+		return 0, true
+	}
+
+	if tx.Exec(nil, "UPDATE playlists SET item_count = s.item_count, total_duration = s.total_duration FROM playlists s WHERE playlists.id = $1 AND s.id = $2", id, source) {
+		return 0, true
+	}
+
+	// item orders are unique within a playlist, so they identify the current item
+	if tx.Exec(nil, `
+		UPDATE playlists SET current = (
+			SELECT c.id
+			FROM playlist_items c
+			JOIN playlist_items s ON s.item_order = c.item_order
+			JOIN playlists p ON p.current = s.id
+			WHERE c.playlist = $1 AND p.id = $2
+		) WHERE id = $1`, id, source) {
+		return 0, true
+	}
+
+	if tx.Exec(nil, "INSERT INTO alt_metadata (playlist, media, alt_title, alt_artist) SELECT $1, media, alt_title, alt_artist FROM alt_metadata WHERE playlist = $2", id, source) {
+		return 0, true
+	}
+
+	return id, false
+}
+
+// Insert the items of source into playlist at pos, keeping their order and
+// trims. Alternative metadata of source is kept for medias that have none in
+// playlist yet.
+func MergePlaylist(tx *db.Tx, playlist, source int, pos PlaylistAddPosition) (numItems int, hasErr bool) {
+	if playlist == source {
+		tx.PublicError(http.StatusUnprocessableEntity, SelfMergeError)
+		return 0, true
+	}
+
+	var rows *sql.Rows
+	if tx.Query(&rows, "SELECT media, COALESCE(start_offset, 0), COALESCE(end_offset, 0) FROM playlist_items WHERE playlist = $1 ORDER BY item_order", source) {
+		return 0, true
+	}
+
+	var mediaIds []int
+	var trims []media.Trim
+	for rows.Next() {
+		var mediaId, start, end int
+		if err := rows.Scan(&mediaId, &start, &end); err != nil {
+			rows.Close()
+			tx.PrivateError(err)
+			tx.PublicError(http.StatusInternalServerError, db.GenericError)
+			return 0, true
+		}
+
+		mediaIds = append(mediaIds, mediaId)
+		trims = append(trims, media.Trim{Start: time.Duration(start) * time.Second, End: time.Duration(end) * time.Second})
+	}
+	rows.Close()
+
+	ids, hasErr := InsertPlaylistItems(tx, playlist, mediaIds, pos)
+	if hasErr {
+		return 0, true
+	}
+
+	for i, trim := range trims {
+		if trim != (media.Trim{}) && SetPlaylistItemTrim(tx, ids[i], trim) {
+			return 0, true
+		}
+	}
+
+	if tx.Exec(nil, "INSERT INTO alt_metadata (playlist, media, alt_title, alt_artist) SELECT $1, media, alt_title, alt_artist FROM alt_metadata WHERE playlist = $2 ON CONFLICT (playlist, media) DO NOTHING", playlist, source) {
+		return 0, true
+	}
+
+	return len(ids), false
+}
+
 func RenamePlaylist(tx *db.Tx, username string, id int, name string) (hasErr bool) {
 	return tx.Exec(nil, "UPDATE playlists SET name = $1 WHERE id = $2 AND owner_username = $3", name, id, username)
 }
@@ -192,7 +513,7 @@ func CheckPlaylistExists(tx *db.Tx, id int) (hasRow bool, hasErr bool) {
 }
 
 func SetCurrentMedia(tx *db.Tx, playlist int, itemId sql.NullInt32) (hasErr bool) {
-	return tx.Exec(nil, "UPDATE playlists SET current = $1, current_version = current_version + 1 WHERE id = $2", itemId, playlist)
+	return tx.Exec(nil, "UPDATE playlists SET current = $1, current_version = current_version + 1, last_played = CASE WHEN $1::INT IS NULL THEN last_played ELSE NOW() END WHERE id = $2", itemId, playlist)
 }
 
 func GetCurrentMedia(tx *db.Tx, playlist int) (itemId sql.NullInt32, hasErr bool) {
@@ -218,10 +539,21 @@ func PlaylistUpdateCurrent(tx *db.Tx, handler errs.ErrorHandler, playlist int, s
 		return nil, true
 	}
 
+	// unavailable medias are skipped, unless nothing else can be played
 	var next int
-	if tx.QueryRow("SELECT id FROM playlist_items WHERE playlist = $1 AND item_order "+sign+" $2 ORDER BY item_order "+sortOrder, playlist, currentOrder).Scan(&hasRow, &next) {
+	if tx.QueryRow("SELECT i.id FROM playlist_items i JOIN medias m ON m.id = i.media WHERE i.playlist = $1 AND i.item_order "+sign+" $2 AND m.status <> 'unavailable' ORDER BY i.item_order "+sortOrder, playlist, currentOrder).Scan(&hasRow, &next) {
 		return nil, true
 	}
+	if !hasRow {
+		if tx.QueryRow("SELECT i.id FROM playlist_items i JOIN medias m ON m.id = i.media WHERE i.playlist = $1 AND m.status <> 'unavailable' ORDER BY i.item_order "+sortOrder, playlist).Scan(&hasRow, &next) {
+			return nil, true
+		}
+	}
+	if !hasRow {
+		if tx.QueryRow("SELECT id FROM playlist_items WHERE playlist = $1 AND item_order "+sign+" $2 ORDER BY item_order "+sortOrder, playlist, currentOrder).Scan(&hasRow, &next) {
+			return nil, true
+		}
+	}
 	if !hasRow {
 		if tx.QueryRow("SELECT id FROM playlist_items WHERE playlist = $1 ORDER BY item_order "+sortOrder, playlist).Scan(nil, &next) {
 			return nil, true
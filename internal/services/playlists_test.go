@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePlaylistTags(t *testing.T) {
+	tests := []struct {
+		input string
+		tags  []string
+	}{
+		{"", nil},
+		{" , ,", nil},
+		{"Rock, #Jazz ,rock", []string{"rock", "jazz"}},
+		{"  lo   fi  beats ", []string{"lo fi beats"}},
+		{"J-Pop,#j-pop,ＡＢＣ", []string{"j-pop", "ａｂｃ"}},
+	}
+
+	for _, test := range tests {
+		tags, err := ParsePlaylistTags(test.input)
+		if err != nil {
+			t.Errorf("ParsePlaylistTags(%q): %v", test.input, err)
+		} else if !slices.Equal(tags, test.tags) {
+			t.Errorf("ParsePlaylistTags(%q) = %q, expected %q", test.input, tags, test.tags)
+		}
+	}
+
+	long := strings.Repeat("é", MaxPlaylistTagLength)
+	if tags, err := ParsePlaylistTags(long); err != nil || len(tags) != 1 {
+		t.Errorf("expected tag of %d characters to be accepted, got %q, %v", MaxPlaylistTagLength, tags, err)
+	}
+
+	if _, err := ParsePlaylistTags(long + "e"); !errors.Is(err, TagTooLongError) {
+		t.Errorf("expected TagTooLongError, got %v", err)
+	}
+
+	var many []string
+	for i := range MaxPlaylistTags + 1 {
+		many = append(many, fmt.Sprintf("tag%d", i))
+	}
+
+	if _, err := ParsePlaylistTags(strings.Join(many, ",")); !errors.Is(err, TooManyTagsError) {
+		t.Errorf("expected TooManyTagsError, got %v", err)
+	}
+
+	// duplicates do not count towards the limit
+	if _, err := ParsePlaylistTags(strings.Join(many[:MaxPlaylistTags], ",") + ",tag0"); err != nil {
+		t.Errorf("expected duplicate tag to be dropped, got %v", err)
+	}
+}
+
+func TestSearchTsQuery(t *testing.T) {
+	tests := []struct {
+		query, expected string
+	}{
+		{"", ""},
+		{"  !!  ", ""},
+		{"Lofi", "'lofi':*"},
+		{"lo-fi  beats 2024", "'lo':* & 'fi':* & 'beats':* & '2024':*"},
+		// tsquery operators and quotes are never passed through
+		{"a' | b & !c:*", "'a':* & 'b':* & 'c':*"},
+		{"Ánh Sáng", "'ánh':* & 'sáng':*"},
+		{"東京 live", "'東京':* & 'live':*"},
+	}
+
+	for _, test := range tests {
+		if tsQuery := searchTsQuery(test.query); tsQuery != test.expected {
+			t.Errorf("searchTsQuery(%q) = %q, expected %q", test.query, tsQuery, test.expected)
+		}
+	}
+}
+
+func TestLikeEscaper(t *testing.T) {
+	if escaped := likeEscaper.Replace(`100%_off\`); escaped != `100\%\_off\\` {
+		t.Errorf("unexpected escaped pattern %q", escaped)
+	}
+}
+
+func TestParsePlaylistSort(t *testing.T) {
+	for input, expected := range map[string]PlaylistSort{
+		"":                         SortRelevance,
+		string(SortNewest):         SortNewest,
+		string(SortMostItems):      SortMostItems,
+		string(SortRecentlyPlayed): SortRecentlyPlayed,
+	} {
+		if sort, err := ParsePlaylistSort(input); err != nil || sort != expected {
+			t.Errorf("ParsePlaylistSort(%q) = %q, %v, expected %q", input, sort, err, expected)
+		}
+	}
+
+	if _, err := ParsePlaylistSort("name; DROP TABLE playlists"); err == nil {
+		t.Error("expected unknown sort order to be rejected")
+	}
+}
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/btmxh/plst4/internal/auth"
+	"github.com/btmxh/plst4/internal/db"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	DefaultThumbnailCacheDir  = "thumbnails"
+	DefaultThumbnailCacheSize = 256 << 20
+
+	thumbnailWidth        = 320
+	thumbnailQuality      = 85
+	maxThumbnailFetchSize = 16 << 20
+	thumbnailFetchTimeout = 30 * time.Second
+	// failed fetches are not retried before that
+	thumbnailFailureTTL = time.Hour
+
+	thumbnailSignaturePurpose = "thumbnail"
+
+	// larger images take too much memory to decode (4 bytes per pixel)
+	maxImagePixels = 40_000_000
+)
+
+var thumbnailFetchError = errors.New("Unable to fetch thumbnail")
+var invalidThumbnailSignatureError = errors.New("Invalid thumbnail signature")
+var ImageTooLargeError = fmt.Errorf("Images can have at most %d megapixels.", maxImagePixels/1_000_000)
+
+type thumbnailCacheEntry struct {
+	name string
+	size int64
+}
+
+// Resized copies of media thumbnails stored on disk, evicting the least
+// recently used ones once the total size exceeds maxSize.
+type ThumbnailCache struct {
+	dir     string
+	maxSize int64
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+	// names of thumbnails whose fetch failed, until when not to retry them
+	failures map[string]time.Time
+	mutex    sync.Mutex
+	group    singleflight.Group
+	client   *http.Client
+}
+
+var thumbnailCache *ThumbnailCache
+
+func NewThumbnailCache(dir string, maxSize int64) (*ThumbnailCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &ThumbnailCache{
+		dir:      dir,
+		maxSize:  maxSize,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		failures: make(map[string]time.Time),
+		client:   &http.Client{Timeout: thumbnailFetchTimeout},
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// modification times are bumped on access, so they give the LRU order of
+	// the previous run
+	type cachedFile struct {
+		entry   thumbnailCacheEntry
+		modTime time.Time
+	}
+	var cached []cachedFile
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".jpg") {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		cached = append(cached, cachedFile{entry: thumbnailCacheEntry{name: file.Name(), size: info.Size()}, modTime: info.ModTime()})
+	}
+
+	slices.SortFunc(cached, func(a, b cachedFile) int { return a.modTime.Compare(b.modTime) })
+	for _, file := range cached {
+		c.add(file.entry)
+	}
+	c.evict()
+
+	return c, nil
+}
+
+func InitThumbnailCache(dir string, maxSize int64) error {
+	cache, err := NewThumbnailCache(dir, maxSize)
+	if err != nil {
+		return err
+	}
+
+	thumbnailCache = cache
+	return nil
+}
+
+func GetCachedThumbnail(ctx context.Context, mediaId int, upstream string) (string, error) {
+	return thumbnailCache.Get(ctx, thumbnailFileName(mediaId, upstream), upstream)
+}
+
+// Get the URL serving upstream through the thumbnail cache, for thumbnails of
+// medias not stored yet (e.g. search results). Empty for local thumbnails.
+func ProxiedThumbnailURL(upstream string) string {
+	if !strings.HasPrefix(upstream, "http") {
+		return ""
+	}
+
+	return "/thumbnails/proxy?" + url.Values{
+		"url": {upstream},
+		"sig": {auth.Sign(thumbnailSignaturePurpose, upstream)},
+	}.Encode()
+}
+
+// Like GetCachedThumbnail, for URLs given by ProxiedThumbnailURL. Only signed
+// URLs are fetched, so that this is not an open proxy.
+func GetProxiedThumbnail(ctx context.Context, upstream, signature string) (string, error) {
+	if !strings.HasPrefix(upstream, "http") || !auth.CheckSignature(thumbnailSignaturePurpose, upstream, signature) {
+		return "", invalidThumbnailSignatureError
+	}
+
+	hash := sha1.Sum([]byte(upstream))
+	return thumbnailCache.Get(ctx, fmt.Sprintf("proxy-%s.jpg", hex.EncodeToString(hash[:8])), upstream)
+}
+
+// Files are named after the media ID and a hash of the upstream URL, so
+// changed thumbnails are fetched again.
+func thumbnailFileName(mediaId int, upstream string) string {
+	hash := sha1.Sum([]byte(upstream))
+	return fmt.Sprintf("%d-%s.jpg", mediaId, hex.EncodeToString(hash[:6]))
+}
+
+func (c *ThumbnailCache) add(entry thumbnailCacheEntry) {
+	c.entries[entry.name] = c.lru.PushBack(entry)
+	c.size += entry.size
+}
+
+// Evict thumbnails until the cache fits, always keeping the most recently
+// used one (which might be the one just fetched).
+func (c *ThumbnailCache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 1 {
+		elem := c.lru.Front()
+		entry := c.lru.Remove(elem).(thumbnailCacheEntry)
+		delete(c.entries, entry.name)
+		c.size -= entry.size
+
+		if err := os.Remove(filepath.Join(c.dir, entry.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Unable to remove evicted thumbnail", "name", entry.name, "err", err)
+		}
+	}
+}
+
+func (c *ThumbnailCache) lookup(name string) (path string, ok bool, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if until, failed := c.failures[name]; failed {
+		if time.Now().Before(until) {
+			return "", false, thumbnailFetchError
+		}
+
+		delete(c.failures, name)
+	}
+
+	elem, ok := c.entries[name]
+	if !ok {
+		return "", false, nil
+	}
+
+	c.lru.MoveToBack(elem)
+	path = filepath.Join(c.dir, name)
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return path, true, nil
+}
+
+func (c *ThumbnailCache) setFailed(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for name, until := range c.failures {
+		if now.After(until) {
+			delete(c.failures, name)
+		}
+	}
+
+	c.failures[name] = now.Add(thumbnailFailureTTL)
+}
+
+// Get the path of the cached thumbnail called name, fetching it from upstream
+// if it is not cached yet.
+func (c *ThumbnailCache) Get(ctx context.Context, name, upstream string) (string, error) {
+	if path, ok, err := c.lookup(name); ok || err != nil {
+		return path, err
+	}
+
+	// the fetch is shared by all callers, so it must not be cancelled with
+	// the first of them (the client has its own timeout)
+	sharedCtx := context.WithoutCancel(ctx)
+	result := c.group.DoChan(name, func() (interface{}, error) {
+		path, err := c.fetch(sharedCtx, name, upstream)
+		if err != nil {
+			slog.Debug("Unable to fetch thumbnail", "upstream", upstream, "err", err)
+			c.setFailed(name)
+		}
+
+		return path, err
+	})
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return "", res.Err
+		}
+
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (c *ThumbnailCache) fetch(ctx context.Context, name, upstream string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s returned %s", thumbnailFetchError, upstream, res.Status)
+	}
+
+	img, err := decodeImage(res.Body, maxThumbnailFetchSize)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp(c.dir, "fetch-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err = jpeg.Encode(file, resizeThumbnail(img), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return "", err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(c.dir, name)
+	if err = os.Rename(file.Name(), path); err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.add(thumbnailCacheEntry{name: name, size: info.Size()})
+	c.evict()
+	return path, nil
+}
+
+// Decode an image of at most maxSize bytes, checking its dimensions before
+// allocating its pixels: a few bytes of compressed data can declare a huge
+// image.
+func decodeImage(r io.Reader, maxSize int64) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, ImageTooLargeError
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+func resizeThumbnail(img image.Image) image.Image {
+	return resizeImage(img, thumbnailWidth)
+}
+
+// Scale img down to width, keeping its aspect ratio.
+func resizeImage(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	resized := image.NewRGBA(image.Rect(0, 0, width, max(height, 1)))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+	return resized
+}
+
+func GetMediaThumbnail(tx *db.Tx, id int) (thumbnail sql.NullString, hasRow, hasErr bool) {
+	hasErr = tx.QueryRow("SELECT thumbnail FROM medias WHERE id = $1", id).Scan(&hasRow, &thumbnail)
+	return thumbnail, hasRow, hasErr
+}
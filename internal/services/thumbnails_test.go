@@ -0,0 +1,145 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestThumbnailCache(t *testing.T) *ThumbnailCache {
+	cache, err := NewThumbnailCache(t.TempDir(), DefaultThumbnailCacheSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cache
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestThumbnailCacheGet(t *testing.T) {
+	body := testPNG(t, 640, 360)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	cache := newTestThumbnailCache(t)
+	for range 2 {
+		if _, err := cache.Get(context.Background(), "1-test.jpg", server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 upstream request, got %d", n)
+	}
+}
+
+func TestThumbnailCacheFailure(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	cache := newTestThumbnailCache(t)
+	for range 2 {
+		if _, err := cache.Get(context.Background(), "1-test.jpg", server.URL); !errors.Is(err, thumbnailFetchError) {
+			t.Fatalf("expected thumbnailFetchError, got %v", err)
+		}
+	}
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected failed fetch to be cached, got %d upstream requests", n)
+	}
+}
+
+func TestThumbnailCacheTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pngHeader(100_000, 100_000))
+	}))
+	defer server.Close()
+
+	cache := newTestThumbnailCache(t)
+	if _, err := cache.Get(context.Background(), "1-test.jpg", server.URL); !errors.Is(err, ImageTooLargeError) {
+		t.Fatalf("expected ImageTooLargeError, got %v", err)
+	}
+}
+
+func TestThumbnailCacheCancel(t *testing.T) {
+	body := testPNG(t, 16, 16)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write(body)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cache := newTestThumbnailCache(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.Get(ctx, "1-test.jpg", server.URL)
+		first <- err
+	}()
+
+	second := make(chan error, 1)
+	go func() {
+		// joins the fetch started by the first caller
+		time.Sleep(50 * time.Millisecond)
+		_, err := cache.Get(context.Background(), "1-test.jpg", server.URL)
+		second <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected first caller to be cancelled, got %v", err)
+	}
+
+	release <- struct{}{}
+	if err := <-second; err != nil {
+		t.Fatalf("shared fetch failed after first caller was cancelled: %v", err)
+	}
+}
+
+func TestProxiedThumbnailURL(t *testing.T) {
+	if u := ProxiedThumbnailURL("/assets/local.svg"); u != "" {
+		t.Errorf("expected local thumbnail not to be proxied, got %q", u)
+	}
+
+	upstream := "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg"
+	proxied, err := url.Parse(ProxiedThumbnailURL(upstream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if proxied.Path != "/thumbnails/proxy" || proxied.Query().Get("url") != upstream {
+		t.Fatalf("unexpected proxied URL %q", proxied)
+	}
+
+	sig := proxied.Query().Get("sig")
+	if _, err := GetProxiedThumbnail(context.Background(), "https://example.com/a.jpg", sig); !errors.Is(err, invalidThumbnailSignatureError) {
+		t.Errorf("expected signature of another URL to be rejected, got %v", err)
+	}
+}
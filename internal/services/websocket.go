@@ -1,6 +1,7 @@
 package services
 
 import (
+	"encoding/json"
 	"errors"
 	"html/template"
 	"log/slog"
@@ -27,6 +28,8 @@ const (
 	Swap         WebSocketMsgType = "swap"
 	Event        WebSocketMsgType = "event"
 	MediaChanged WebSocketMsgType = "media-change"
+	// sent by clients whose player failed to play the current media
+	PlaybackError WebSocketMsgType = "playback-error"
 
 	ManagersChanged WebSocketEventType = "refresh-managers"
 	PlaylistChanged WebSocketEventType = "refresh-playlist"
@@ -39,6 +42,10 @@ type MediaChangedPayload struct {
 	Url         string          `json:"url"`
 	AspectRatio string          `json:"aspectRatio"`
 	NewVersion  int             `json:"newVersion"`
+	// trim offsets in seconds, zero if the media is played from the start or
+	// until the end
+	Start int `json:"start"`
+	End   int `json:"end"`
 }
 
 type WebSocketMsg struct {
@@ -46,6 +53,12 @@ type WebSocketMsg struct {
 	Payload interface{}      `json:"payload"`
 }
 
+// Messages sent by clients, the payload is decoded depending on the type.
+type WebSocketClientMsg struct {
+	Type    WebSocketMsgType `json:"type"`
+	Payload json.RawMessage  `json:"payload"`
+}
+
 type WebSocketManager struct {
 	playlists map[int]*PlaylistState
 	sockets   map[string]*websocket.Conn
@@ -55,6 +68,10 @@ type WebSocketManager struct {
 type PlaylistState struct {
 	userSockets   map[string]map[string]*websocket.Conn
 	nextRequested map[string]struct{}
+	// viewers who failed to play the current version of the playlist
+	failedVersion  int
+	failureReports map[string]struct{}
+	failureHandled bool
 }
 
 func send(id string, ws *websocket.Conn, msg WebSocketMsg) {
@@ -108,8 +125,9 @@ func (manager *WebSocketManager) Add(conn *websocket.Conn, playlist int, usernam
 	id := uniuri.New()
 	if _, ok := manager.playlists[playlist]; !ok {
 		manager.playlists[playlist] = &PlaylistState{
-			userSockets:   make(map[string]map[string]*websocket.Conn),
-			nextRequested: make(map[string]struct{}),
+			userSockets:    make(map[string]map[string]*websocket.Conn),
+			nextRequested:  make(map[string]struct{}),
+			failureReports: make(map[string]struct{}),
 		}
 	}
 
@@ -126,6 +144,16 @@ func (manager *WebSocketManager) Remove(playlist int, username string, id string
 	if manager.playlists[playlist].Remove(username, id) {
 		delete(manager.playlists, playlist)
 	}
+
+	delete(manager.sockets, id)
+}
+
+func (manager *WebSocketManager) HasSocket(id string) bool {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+
+	_, ok := manager.sockets[id]
+	return ok
 }
 
 func (manager *WebSocketManager) SendId(id string, msg WebSocketMsg) {
@@ -146,6 +174,31 @@ func (manager *WebSocketManager) BroadcastPlaylist(id int, msg WebSocketMsg) {
 	}
 }
 
+// Close the sockets of playlist whose users are matched by shouldDisconnect,
+// sending them msg beforehand. Handlers of the sockets clean up as usual.
+func (manager *WebSocketManager) Disconnect(playlist int, msg WebSocketMsg, shouldDisconnect func(username string) bool) {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+
+	p, ok := manager.playlists[playlist]
+	if !ok {
+		return
+	}
+
+	for username, sockets := range p.userSockets {
+		if !shouldDisconnect(username) {
+			continue
+		}
+
+		for socketId, socket := range sockets {
+			send(socketId, socket, msg)
+			if err := socket.Close(); err != nil {
+				slog.Warn("Unable to close WebSocket connection", "sid", socketId, "err", err)
+			}
+		}
+	}
+}
+
 func WebSocketSwap(socketId string, html template.HTML) {
 	manager.SendId(socketId, WebSocketMsg{
 		Type:    Swap,
@@ -169,6 +222,28 @@ func WebSocketMediaChange(playlist int, socketId string, payload MediaChangedPay
 	}
 }
 
+func WebSocketPlaylistToast(playlist int, kind html.ToastKind, title template.HTML, description template.HTML) error {
+	var str strings.Builder
+	if err := html.RenderToast(&str, kind, title, description); err != nil {
+		slog.Warn("error rendering toast notification for WebSocket", "err", err)
+		return err
+	}
+
+	manager.BroadcastPlaylist(playlist, WebSocketMsg{Type: Swap, Payload: str.String()})
+	return nil
+}
+
+// Disconnect the viewers of playlist matched by shouldDisconnect, telling them
+// that they lost access to it.
+func WebSocketDisconnect(playlist int, shouldDisconnect func(username string) bool) {
+	var str strings.Builder
+	if err := html.RenderToast(&str, html.ToastError, "Disconnected", "You are no longer allowed to view this playlist."); err != nil {
+		slog.Warn("error rendering toast notification for WebSocket", "err", err)
+	}
+
+	manager.Disconnect(playlist, WebSocketMsg{Type: Swap, Payload: str.String()}, shouldDisconnect)
+}
+
 func WebSocketPlaylistEvent(playlist int, event WebSocketEventType) {
 	manager.BroadcastPlaylist(playlist, WebSocketMsg{Type: Event, Payload: event})
 }
@@ -8,6 +8,7 @@ export class Html5Player extends Player {
     super();
     this.player = player;
     this.player.addEventListener("ended", () => this.nextRequest());
+    this.player.addEventListener("timeupdate", () => this.onProgress(this.player.currentTime));
     this.player.addEventListener("error", (evt) => {
       if (this.player.src == "") {
         return;
@@ -15,7 +16,7 @@ export class Html5Player extends Player {
       console.debug("HTML5 player error", evt);
       // playwright browsers might not support the necessary codecs
       if (!navigator.webdriver && this.player.error?.code !== 4) {
-        this.nextRequest();
+        this.playbackError(this.player.error?.message || "media could not be loaded");
       }
     });
   }
@@ -34,7 +35,13 @@ export class Html5Player extends Player {
   }
 
   start(payload: MediaChangePayload) {
+    this.setTrim(payload);
     this.player.src = payload.url;
+    if (payload.start > 0) {
+      this.player.addEventListener("loadedmetadata", () => {
+        this.player.currentTime = payload.start;
+      }, { once: true });
+    }
     this.play();
   }
 
@@ -52,7 +52,9 @@ export class Niconico extends Player {
     this.player.addEventListener('load', () => {
       this.playerLoaded = true;
     });
-    this.player.src = `${Niconico.origin}/watch/${id}?jsapi=1&playerId=${playerId}&autoplay=1`;
+    this.setTrim(payload);
+    const from = payload.start > 0 ? `&from=${payload.start}` : "";
+    this.player.src = `${Niconico.origin}/watch/${id}?jsapi=1&playerId=${playerId}&autoplay=1${from}`;
     this.player.id = "niconico-video-player";
     this.player.allow = "autoplay; fullscreen";
     this.container.replaceChildren(this.player);
@@ -89,8 +91,12 @@ export class Niconico extends Player {
       this.nextRequest();
     }
 
+    if (e.data.eventName === "playerMetadataChange") {
+      this.onProgress(e.data.data.playerMetadata.currentTime / 1000);
+    }
+
     if (e.data.eventName === "error") {
-      this.nextRequest();
+      this.playbackError("Niconico player error");
     }
   }
 }
@@ -13,6 +13,9 @@ export const waitUntilDefined = (fn: () => any, callback: () => void) => {
 let lastNextRequest = -Infinity;
 
 export class Player {
+  trimEnd = 0;
+  trimEndReached = false;
+
   play() {
   }
 
@@ -31,6 +34,26 @@ export class Player {
   show() {
   }
 
+  setTrim(payload: MediaChangePayload) {
+    this.trimEnd = payload.end;
+    this.trimEndReached = false;
+  }
+
+  // players call this as the media plays, to move on once the trim end is
+  // reached
+  onProgress(seconds: number) {
+    if (this.trimEnd > 0 && seconds >= this.trimEnd && !this.trimEndReached) {
+      this.trimEndReached = true;
+      this.pause();
+      this.nextRequest();
+    }
+  }
+
+  // let the server skip the media if other viewers cannot play it either
+  playbackError(reason: string) {
+    document.body.dispatchEvent(new CustomEvent("playback-error", { detail: reason }));
+  }
+
   async nextRequest() {
     const now = Date.now();
     // ratelimit
@@ -33,9 +33,12 @@ export class SoundCloud extends Player {
         player.bind(SC.Widget.Events.FINISH, () => {
           this.nextRequest();
         });
+        player.bind(SC.Widget.Events.PLAY_PROGRESS, (e: { currentPosition: number }) => {
+          this.onProgress(e.currentPosition / 1000);
+        });
         player.bind(SC.Widget.Events.ERROR, () => {
           console.debug("SoundCloud embed player error");
-          this.nextRequest();
+          this.playbackError("SoundCloud player error");
         });
       });
     });
@@ -73,6 +76,14 @@ export class SoundCloud extends Player {
       return
     }
 
-    this.player.load(payload.url + "?auto_play=true");
+    this.setTrim(payload);
+    this.player.load(payload.url + "?auto_play=true", {
+      auto_play: true,
+      callback: () => {
+        if (payload.start > 0) {
+          this.player.seekTo(payload.start * 1000);
+        }
+      },
+    });
   }
 }
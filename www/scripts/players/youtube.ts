@@ -1,6 +1,15 @@
 import { MediaChangePayload } from "../websocket.js";
 import { Player, waitUntilDefined } from "./player.js";
 
+// https://developers.google.com/youtube/iframe_api_reference#onError
+const youtubeErrorReasons: Record<number, string> = {
+  2: "invalid video ID",
+  5: "video cannot be played in an HTML5 player",
+  100: "video not found, removed or private",
+  101: "embedding disabled by the video owner",
+  150: "embedding disabled by the video owner",
+};
+
 export class Youtube extends Player {
   player: YT.Player | undefined
   pendingPayload: MediaChangePayload | undefined
@@ -40,7 +49,7 @@ export class Youtube extends Player {
             },
             onError: (err) => {
               console.debug("YouTube embed player error", err);
-              this.nextRequest();
+              this.playbackError(youtubeErrorReasons[err.data] ?? `YouTube player error ${err.data}`);
             }
           }
         })
@@ -80,8 +89,13 @@ export class Youtube extends Player {
       return
     }
 
+    // the player ends by itself at endSeconds
     const id = payload.url.substring("https://youtu.be/".length);
-    this.player.loadVideoById(id);
+    this.player.loadVideoById({
+      videoId: id,
+      startSeconds: payload.start || undefined,
+      endSeconds: payload.end || undefined,
+    });
     (document.querySelector("#youtube-video-player-wrapper") as HTMLElement).style.aspectRatio = payload.aspectRatio;
   }
 }
@@ -48,6 +48,22 @@ const socket = new Plst4Socket((msg) => {
   }
 });
 
+document.body.addEventListener("playback-error", (evt) => {
+  const inp = document.querySelector<HTMLInputElement>("#playlist-current-version-input");
+  if (inp === null) {
+    console.error("Malformed HTML: current version input not found");
+    return;
+  }
+
+  socket.send({
+    type: "playback-error",
+    payload: {
+      version: parseInt(inp.value),
+      reason: (evt as CustomEvent<string>).detail,
+    },
+  });
+});
+
 const players = {
   "yt": new Youtube(),
   "testvideo": new TestVideoPlayer(),
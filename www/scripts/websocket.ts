@@ -4,6 +4,13 @@ export type MediaChangePayload = {
   url: string
   aspectRatio: string
   newVersion: number
+  // trim offsets in seconds, 0 if unset
+  start: number
+  end: number
+}
+export type PlaybackErrorPayload = {
+  version: number
+  reason: string
 }
 export type SocketMsg = {
   type: "handshake" | "swap" | "event"
@@ -11,6 +18,9 @@ export type SocketMsg = {
 } | {
   type: "media-change"
   payload: MediaChangePayload
+} | {
+  type: "playback-error"
+  payload: PlaybackErrorPayload
 }
 
 export class Plst4Socket {